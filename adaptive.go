@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// latencyTolerance is how much slower than their baseline renders may get
+// before -adaptiveConcurrency backs off.
+const latencyTolerance = 1.5
+
+// adaptiveConcurrency tunes the number of renders in flight between min and
+// max (-adaptiveConcurrency). After every round of renders, as many as the
+// current limit, it adds one while renders are as fast as their baseline,
+// halves the limit when the server failed any of them, and shrinks it in
+// proportion when they got slower. The render semaphore has max slots; the
+// ones above the limit are held by the controller.
+type adaptiveConcurrency struct {
+	sem      *semaphore.Weighted
+	min, max int64
+
+	mu       sync.Mutex
+	limit    int64
+	renders  int64
+	failures int64
+	total    time.Duration
+	baseline time.Duration
+	reason   string
+
+	// held is the number of slots taken away; it is only touched by the
+	// dispatching goroutine.
+	held int64
+}
+
+func newAdaptiveConcurrency(start, min, max int) (*adaptiveConcurrency, error) {
+	if min < 1 || max < min || start < min || start > max {
+		return nil, fmt.Errorf("need 1 <= min (%d) <= -concurrency (%d) <= max (%d)", min, start, max)
+	}
+
+	a := &adaptiveConcurrency{sem: semaphore.NewWeighted(int64(max)), min: int64(min), max: int64(max), limit: int64(start)}
+	a.held = a.max - a.limit
+	a.sem.TryAcquire(a.held)
+	return a, nil
+}
+
+// observe records a render that took d, failed telling whether the server
+// failed it, and moves the limit once a round is complete.
+func (a *adaptiveConcurrency) observe(d time.Duration, failed bool) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.renders++
+	a.total += d
+	if failed {
+		a.failures++
+	}
+	if a.renders < a.limit {
+		return
+	}
+
+	avg := a.total / time.Duration(a.renders)
+	limit := a.limit
+	switch {
+	case a.failures > 0:
+		limit /= 2
+		a.reason = fmt.Sprintf("%d of %d renders failed", a.failures, a.renders)
+	case a.baseline > 0 && float64(avg) > latencyTolerance*float64(a.baseline):
+		limit = int64(float64(limit) * float64(a.baseline) / float64(avg))
+		if limit < a.limit/2 {
+			limit = a.limit / 2
+		}
+		a.reason = fmt.Sprintf("renders slowed down to %s from %s", avg.Round(time.Millisecond), a.baseline.Round(time.Millisecond))
+	default:
+		limit++
+		a.reason = fmt.Sprintf("renders take %s", avg.Round(time.Millisecond))
+	}
+	if limit < a.min {
+		limit = a.min
+	}
+	if limit > a.max {
+		limit = a.max
+	}
+	a.limit = limit
+
+	// the baseline is the fastest round, drifting up slowly so that a
+	// server that got slower for good isn't held to an early best
+	if a.baseline == 0 || avg < a.baseline {
+		a.baseline = avg
+	} else if a.failures == 0 {
+		a.baseline += (avg - a.baseline) / 20
+	}
+	a.renders, a.failures, a.total = 0, 0, 0
+}
+
+// adjust is called before each capture is dispatched, to take or give back
+// the slots the limit moved by.
+func (a *adaptiveConcurrency) adjust(logger *log.Logger) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	held, reason := a.max-a.limit, a.reason
+	a.mu.Unlock()
+
+	switch {
+	case held > a.held:
+		logger.Printf("adaptive concurrency: lowering to %d, %s", a.max-held, reason)
+		if err := a.sem.Acquire(ctx, held-a.held); err != nil {
+			logger.Printf("failed to acquire semaphore: %v", err)
+			return
+		}
+	case held < a.held:
+		logger.Printf("adaptive concurrency: raising to %d, %s", a.max-held, reason)
+		a.sem.Release(a.held - held)
+	default:
+		return
+	}
+	a.held = held
+}
+
+// stop gives back the slots held, so the last captures can finish.
+func (a *adaptiveConcurrency) stop() {
+	if a != nil && a.held > 0 {
+		a.sem.Release(a.held)
+		a.held = 0
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	a, err := newAdaptiveConcurrency(4, 1, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	// each round is as many renders as the limit, failed of them failing
+	rounds := []struct {
+		d      time.Duration
+		failed int
+		limit  int64
+	}{
+		{100 * time.Millisecond, 0, 5}, // sets the baseline
+		{100 * time.Millisecond, 1, 2}, // halved
+		{100 * time.Millisecond, 0, 3},
+		{200 * time.Millisecond, 0, 1}, // twice as slow, cut in proportion
+		{400 * time.Millisecond, 0, 1}, // not below min
+		{50 * time.Millisecond, 0, 2},  // a faster baseline
+		{50 * time.Millisecond, 0, 3},
+	}
+	for i, r := range rounds {
+		n := int(a.limit)
+		for k := 0; k < n; k++ {
+			if k == n-1 && a.limit != int64(n) {
+				t.Fatalf("round %d: limit moved to %d before the round was done", i, a.limit)
+			}
+			a.observe(r.d, k < r.failed)
+		}
+		if a.limit != r.limit {
+			t.Errorf("round %d: limit %d, want %d", i, a.limit, r.limit)
+		}
+
+		// the controller holds the slots above the limit
+		a.adjust(logger)
+		if !a.sem.TryAcquire(a.limit) {
+			t.Fatalf("round %d: can't take %d slots", i, a.limit)
+		}
+		if a.sem.TryAcquire(1) {
+			t.Fatalf("round %d: more than %d slots free", i, a.limit)
+		}
+		a.sem.Release(a.limit)
+	}
+
+	a.stop()
+	if !a.sem.TryAcquire(8) {
+		t.Error("stop didn't give back every slot")
+	}
+}
+
+func TestAdaptiveConcurrencyMax(t *testing.T) {
+	a, err := newAdaptiveConcurrency(2, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.observe(time.Millisecond, false)
+	a.observe(time.Millisecond, false)
+	if a.limit != 2 {
+		t.Errorf("limit %d, want it held at max 2", a.limit)
+	}
+}
+
+func TestAdaptiveConcurrencyRange(t *testing.T) {
+	for _, r := range [][3]int{{1, 0, 4}, {5, 1, 4}, {1, 2, 4}, {3, 4, 2}} {
+		if _, err := newAdaptiveConcurrency(r[0], r[1], r[2]); err == nil {
+			t.Errorf("start %d, min %d, max %d: no error", r[0], r[1], r[2])
+		}
+	}
+}
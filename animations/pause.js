@@ -0,0 +1,31 @@
+// Pauses media and auto-advancing carousels for -disableAnimations.
+(function () {
+    document.querySelectorAll("video, audio").forEach(function (media) {
+        try { media.pause(); media.currentTime = 0; } catch (e) {}
+    });
+
+    document.getAnimations && document.getAnimations().forEach(function (animation) {
+        try { animation.finish(); } catch (e) { animation.cancel(); }
+    });
+
+    // Swiper, Slick, Owl and Bootstrap carousels
+    document.querySelectorAll(".swiper, .swiper-container").forEach(function (el) {
+        if (el.swiper && el.swiper.autoplay) { el.swiper.autoplay.stop(); }
+    });
+    if (window.jQuery) {
+        try { window.jQuery(".slick-initialized").slick("slickPause"); } catch (e) {}
+        try { window.jQuery(".owl-carousel").trigger("stop.owl.autoplay"); } catch (e) {}
+    }
+    if (window.bootstrap && window.bootstrap.Carousel) {
+        document.querySelectorAll(".carousel").forEach(function (el) {
+            var carousel = window.bootstrap.Carousel.getInstance(el);
+            if (carousel) { carousel.pause(); }
+        });
+    }
+
+    // anything else advancing on a timer
+    var last = setTimeout(function () {}, 0);
+    for (var id = 0; id <= last; id++) {
+        clearInterval(id);
+    }
+})();
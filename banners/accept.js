@@ -0,0 +1,24 @@
+// Clicks "accept" on common consent dialogs for -dismissBanners, so sites
+// that gate content behind consent render it.
+(function () {
+    var selectors = [
+        "#onetrust-accept-btn-handler",
+        "#CybotCookiebotDialogBodyLevelButtonLevelOptinAllowAll",
+        "#CybotCookiebotDialogBodyButtonAccept",
+        "#didomi-notice-agree-button",
+        ".qc-cmp2-summary-buttons button[mode=primary]",
+        ".fc-cta-consent",
+        "[data-testid='uc-accept-all-button']",
+        "#truste-consent-button",
+        ".osano-cm-accept-all",
+        ".cc-btn.cc-allow",
+        "#cookie_action_close_header",
+        ".cmpboxbtnyes"
+    ];
+    selectors.forEach(function (selector) {
+        var el = document.querySelector(selector);
+        if (el) {
+            try { el.click(); } catch (e) {}
+        }
+    });
+})();
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files of a baseline directory besides the screenshots themselves.
+const (
+	manifestName  = "manifest.json"
+	baselineMasks = "masks.yaml"
+)
+
+// baseline is the set of reference screenshots `diff` compares against. The
+// manifest links every shot of the URL list to its file; shots that couldn't
+// be captured during `diff --bootstrap` are recorded as missing, and later
+// diffs report them as new instead of failing.
+type baseline struct {
+	mu        sync.Mutex
+	dir       string
+	bootstrap bool
+	RunID     string                    `json:"runId"`
+	CreatedAt time.Time                 `json:"createdAt"`
+	Entries   map[string]*baselineEntry `json:"entries"`
+}
+
+type baselineEntry struct {
+	URL     string `json:"url"`
+	Shot    string `json:"shot,omitempty"`
+	File    string `json:"file,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// newBaseline starts an empty baseline in dir for `diff --bootstrap`.
+func newBaseline(dir, runID string) *baseline {
+	return &baseline{dir: dir, bootstrap: true, RunID: runID, CreatedAt: time.Now(), Entries: map[string]*baselineEntry{}}
+}
+
+// openBaseline loads the manifest of dir for `diff`.
+func openBaseline(dir string) (*baseline, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s has no baseline yet, create it with diff --bootstrap", dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := &baseline{dir: dir}
+	if err = json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestName, err)
+	}
+	if b.Entries == nil {
+		b.Entries = map[string]*baselineEntry{}
+	}
+	return b, nil
+}
+
+func baselineKey(u string, s shot) string {
+	return u + "|" + s.suffix()
+}
+
+// record adds the outcome of a bootstrap capture to the manifest.
+func (b *baseline) record(u string, s shot, res *captureResult) {
+	e := &baselineEntry{URL: u, Shot: s.suffix()}
+	if res.Status == statusSaved {
+		e.File, e.SHA256 = res.FileName, res.SHA256
+	} else {
+		e.Missing, e.Error = true, res.Error
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.Entries[baselineKey(u, s)] = e
+}
+
+// save writes the manifest once the bootstrap is done.
+func (b *baseline) save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data, err := marshalIndented(b)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(b.dir, manifestName+".tmp")
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(b.dir, manifestName))
+}
+
+// missing counts the shots the bootstrap couldn't capture.
+func (b *baseline) missing() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for _, e := range b.Entries {
+		if e.Missing {
+			n++
+		}
+	}
+	return n
+}
+
+// compare diffs img, the current shot s of u, with its baseline and hands
+// the outcome to the comparison sinks.
+func (b *baseline) compare(runOptions *runOptions, u string, s shot, img image.Image, res *captureResult, logger *log.Logger) {
+	c := &comparison{Path: u, Shot: s.suffix(), A: "baseline", B: "current"}
+	defer func() {
+		for _, sink := range runOptions.sinks {
+			if cs, ok := sink.(comparisonSink); ok {
+				cs.compared(c)
+			}
+		}
+	}()
+
+	b.mu.Lock()
+	e := b.Entries[baselineKey(u, s)]
+	b.mu.Unlock()
+	if e == nil || e.Missing {
+		c.New = true
+		logger.Printf("%s%s has no baseline, reporting it as new", runOptions.value(u), c.Shot)
+		return
+	}
+
+	ref, err := decodeShot(filepath.Join(b.dir, filepath.FromSlash(e.File)))
+	if err != nil {
+		c.Error = fmt.Sprintf("can't read baseline %s: %v", e.File, err)
+		logger.Printf("can't compare %s with its baseline: %s", runOptions.value(u), runOptions.within(c.Error, u, e.File))
+		return
+	}
+
+	ref = runOptions.masks.apply(u, ref)
+	d := diffImages(ref, img)
+	c.DiffPercent = d.percent()
+	c.Differs = d.differing > 0 && c.DiffPercent > runOptions.diffThreshold
+	if !c.Differs {
+		return
+	}
+	logger.Printf("%s%s differs from its baseline: %.2f%% of pixels", runOptions.value(u), c.Shot, c.DiffPercent)
+
+	name := strings.TrimSuffix(res.FileName, "."+extension(res.FileName)) + "-diff.png"
+	c.diffName = name
+	if c.DiffFile, err = storeDiff(runOptions, name, u, d); err != nil {
+		c.Error = err.Error()
+	}
+	runOptions.tickets.file(runOptions, c, ref, img, d, logger)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// legacyParameters are accepted by every screenshot server, including ones
+// that predate the capabilities document.
+var legacyParameters = []string{"TimeoutSeconds", "FileName", "Url", "Width", "Height"}
+
+// capabilities is the document a server may return from its capabilities
+// (or ping) endpoint to advertise which action parameters it understands.
+type capabilities struct {
+	Version    string   `json:"version"`
+	Parameters []string `json:"parameters"`
+}
+
+// paramRequirement ties an action parameter to the option that needs it, so
+// an unsupported option can be reported by the flag the user actually set.
+type paramRequirement struct {
+	param  string
+	option string
+}
+
+func (c *capabilities) supports(param string) bool {
+	params := legacyParameters
+	if c != nil && len(c.Parameters) > 0 {
+		params = c.Parameters
+	}
+
+	for _, p := range params {
+		if strings.EqualFold(p, param) {
+			return true
+		}
+	}
+	return false
+}
+
+// probeServers returns what every one of servers supports: only the
+// parameters all of them advertise can be relied on.
+func probeServers(servers []serverConfig, logger *log.Logger) *capabilities {
+	var common *capabilities
+	for i, s := range servers {
+		caps := probeCapabilities(s, logger)
+		if caps == nil {
+			return nil
+		}
+		if i == 0 {
+			common = caps
+			continue
+		}
+
+		var params []string
+		for _, p := range common.Parameters {
+			if caps.supports(p) {
+				params = append(params, p)
+			}
+		}
+		common = &capabilities{Version: common.Version, Parameters: params}
+	}
+	return common
+}
+
+// probeCapabilities asks the server what it supports. Servers that don't
+// answer with a capabilities document are treated as legacy servers.
+func probeCapabilities(conf serverConfig, logger *log.Logger) *capabilities {
+	if conf.Protocol == protocolGRPC {
+		return probeGRPCCapabilities(conf, logger)
+	}
+	probePath := conf.CapabilitiesPath
+	if probePath == "" {
+		probePath = conf.PingPath
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(conf.url(probePath))
+	if err != nil {
+		logger.Printf("capabilities probe failed, assuming a legacy server: %v", err)
+		return nil
+	}
+
+	defer resp.Body.Close()
+
+	var caps capabilities
+	if resp.StatusCode > 299 || json.NewDecoder(resp.Body).Decode(&caps) != nil {
+		logger.Printf("server %s does not advertise capabilities, assuming a legacy server", conf.name())
+		return nil
+	}
+
+	caps.Parameters = conf.clientParams(caps.Parameters)
+	logger.Printf("server %s version %q supports %s", conf.name(), caps.Version, strings.Join(caps.Parameters, ", "))
+	return &caps
+}
+
+// requiredParams lists the action parameters the current options depend on.
+func requiredParams(runOptions *runOptions) []paramRequirement {
+	reqs := []paramRequirement{
+		{"TimeoutSeconds", "-delay"},
+		{"FileName", "-outputDir"},
+		{"Url", "-file"},
+		{"Width", "-width"},
+		{"Height", "-height"},
+	}
+	for _, d := range runOptions.server.Domains {
+		if len(d.Headers) > 0 {
+			reqs = append(reqs, paramRequirement{"Headers", "domains[" + d.Match + "].headers"})
+		}
+		if len(d.Cookies) > 0 {
+			reqs = append(reqs, paramRequirement{"Cookies", "domains[" + d.Match + "].cookies"})
+		}
+	}
+	if len(runOptions.positions) > 0 {
+		reqs = append(reqs, paramRequirement{"ScrollPercent", "-capturePositions"})
+	}
+	if len(runOptions.frameSelectors) > 0 {
+		reqs = append(reqs, paramRequirement{"FrameSelector", "-frameSelector"})
+	}
+	if runOptions.proxies != nil {
+		reqs = append(reqs, paramRequirement{"Proxy", "-proxies"})
+	}
+	if runOptions.waitForFunction != "" {
+		reqs = append(reqs,
+			paramRequirement{"WaitForFunction", "-waitForFunction"},
+			paramRequirement{"WaitForFunctionTimeoutSeconds", "-waitForFunctionTimeout"})
+	}
+	if !runOptions.freezeTime.IsZero() {
+		reqs = append(reqs, paramRequirement{"InitScript", "-freezeTime"})
+	}
+	if runOptions.seedRandom != 0 {
+		reqs = append(reqs, paramRequirement{"InitScript", "-seedRandom"})
+	}
+	if runOptions.dismissBanners {
+		reqs = append(reqs, paramRequirement{"Style", "-dismissBanners"}, paramRequirement{"Script", "-dismissBanners"})
+	}
+	if runOptions.masks.hasSelectors() {
+		reqs = append(reqs, paramRequirement{"Style", "-masks selectors"})
+	}
+	if runOptions.disableAnims {
+		reqs = append(reqs, paramRequirement{"Style", "-disableAnimations"}, paramRequirement{"Script", "-disableAnimations"})
+	}
+	for _, step := range runOptions.fallbacks {
+		if step.param != "" {
+			reqs = append(reqs, paramRequirement{step.param, "-fallbacks " + step.name})
+		}
+	}
+	return reqs
+}
+
+// rowParams lists the action parameters the options of one input line
+// depend on, named after the column or key that set them.
+func rowParams(row jobOptions) []paramRequirement {
+	var reqs []paramRequirement
+	if row.waitForFunction != "" {
+		reqs = append(reqs, paramRequirement{"WaitForFunction", "waitFor"})
+	}
+	if row.waitForTimeout > 0 {
+		reqs = append(reqs, paramRequirement{"WaitForFunctionTimeoutSeconds", "waitForTimeout"})
+	}
+	if row.disableJS != nil && *row.disableJS {
+		reqs = append(reqs, paramRequirement{"DisableJavaScript", "disableJS"})
+	}
+	if len(row.headers) > 0 {
+		reqs = append(reqs, paramRequirement{"Headers", "headers"})
+	}
+	if len(row.cookies) > 0 {
+		reqs = append(reqs, paramRequirement{"Cookies", "cookies"})
+	}
+	if row.style != "" {
+		reqs = append(reqs, paramRequirement{"Style", "style"})
+	}
+	if row.script != "" {
+		reqs = append(reqs, paramRequirement{"Script", "script"})
+	}
+	return reqs
+}
+
+// checkRow fails an input line whose options need a parameter the server
+// does not understand.
+func checkRow(caps *capabilities, row jobOptions) error {
+	var unsupported []string
+	for _, req := range rowParams(row) {
+		if !caps.supports(req.param) {
+			unsupported = append(unsupported, fmt.Sprintf("%s (needed by %s)", req.param, req.option))
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("server does not support: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// checkCapabilities fails fast when an option needs a parameter the server
+// does not understand, instead of letting the server silently drop it.
+func checkCapabilities(runOptions *runOptions, caps *capabilities) error {
+	var unsupported []string
+	for _, req := range requiredParams(runOptions) {
+		if !caps.supports(req.param) {
+			unsupported = append(unsupported, fmt.Sprintf("%s (needed by %s)", req.param, req.option))
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("server does not support: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// checkpoint is an append-only JSON Lines file with one entry per finished
+// URL, so an interrupted run can be resumed with -resume. Only URLs whose
+// shots were all saved are skipped on resume; failures are tried again.
+type checkpoint struct {
+	mu   sync.Mutex
+	f    *os.File
+	done map[string]bool
+}
+
+type checkpointEntry struct {
+	URL    string `json:"url"`
+	Line   int    `json:"line"`
+	Status string `json:"status"`
+}
+
+// openCheckpoint starts a new state file at path, or continues the existing
+// one when resume is set. Without a path nothing is recorded.
+func openCheckpoint(path string, resume bool) (*checkpoint, error) {
+	if path == "" {
+		if resume {
+			return nil, errors.New("-resume needs the -stateFile of the interrupted run")
+		}
+		return nil, nil
+	}
+	c := &checkpoint{done: map[string]bool{}}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := c.load(path); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.f = f
+	return c, nil
+}
+
+func (c *checkpoint) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e checkpointEntry
+		// a line cut short by a crash is simply not counted as done
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		c.done[e.URL] = e.Status == statusSaved
+	}
+	return scanner.Err()
+}
+
+func (c *checkpoint) completed(u string) bool {
+	return c != nil && c.done[u]
+}
+
+func (c *checkpoint) mark(j job, status string) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(checkpointEntry{URL: j.url, Line: j.line, Status: status})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err = c.f.Write(append(data, '\n'))
+	return err
+}
+
+func (c *checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.f.Close()
+}
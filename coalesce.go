@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// rendered is what a single render produced; it is shared by every capture
+// that was coalesced onto it. The image is in the spool file at path, or in
+// data for images that must not reach the disk unencrypted.
+type rendered struct {
+	path        string
+	data        []byte
+	fileName    string
+	bytes       int64
+	sha256      string
+	statusCode  int
+	contentType string
+	proxy       string
+	server      string
+	diagnostics *responseDiagnostics
+	info        *renderInfo
+	// stored is set for an image streamed into the output as it was
+	// downloaded, with where it went.
+	stored       bool
+	location     string
+	destinations []destinationResult
+}
+
+type memoryImage struct {
+	*bytes.Reader
+}
+
+func (memoryImage) Close() error { return nil }
+
+// open returns the image of out.
+func (out *rendered) open() (io.ReadCloser, error) {
+	if out.path == "" {
+		return memoryImage{bytes.NewReader(out.data)}, nil
+	}
+	return os.Open(out.path)
+}
+
+// decode decodes the image of out.
+func (out *rendered) decode() (image.Image, error) {
+	f, err := out.open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// decodedSize estimates the memory the image of out takes once decoded.
+func (out *rendered) decodedSize() int64 {
+	f, err := out.open()
+	if err != nil {
+		return 0
+	}
+
+	defer f.Close()
+
+	return decodedSize(f)
+}
+
+// discard removes the spool file of out, if it has one.
+func (out *rendered) discard() {
+	if out.path != "" {
+		os.Remove(out.path)
+	}
+}
+
+// coalesceKey identifies captures that would produce the same image: the
+// normalized URL plus every option that influences the render.
+func coalesceKey(u string, opts captureOptions) string {
+	normalized := u
+	if parsed, err := url.Parse(u); err == nil {
+		parsed.Scheme = strings.ToLower(parsed.Scheme)
+		parsed.Host = strings.ToLower(parsed.Host)
+		normalized = parsed.String()
+	}
+
+	return fmt.Sprintf("%s|%+v", normalized, opts)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of screenshoter. Capture commands share the
+// capture flags registered on flag.CommandLine; every command may add its
+// own in its run.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *command, args []string) error
+}
+
+// captureMode tells capture what the run is for.
+type captureMode int
+
+const (
+	modeCapture captureMode = iota
+	modeDiff
+	modeValidate
+)
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "capture", args: "[url]", summary: "Capture the URLs of -file, or the one URL given, saved to -o. This is the default when no command is given.", run: captureCommand(modeCapture)},
+		{name: "diff", summary: "Capture the URLs of -file and compare them against the baseline in -baselineDir.", run: captureCommand(modeDiff)},
+		{name: "validate", summary: "Check the flags, config.yaml and the input, and probe the backend, without capturing.", run: captureCommand(modeValidate)},
+		{name: "serve", summary: "Run capture jobs submitted over an HTTP API (POST /jobs, GET /jobs/{id}, GET /jobs/{id}/files/{name}).", run: runServe},
+		{name: "report", args: "report.json", summary: "Summarize the JSON report of a run.", run: runReportSummary},
+		{name: "search", args: "expression reports...", summary: "List the results of run reports matching an expression such as \"status=failed AND domain=example.com\".", run: runSearch},
+		{name: "replay", args: "report.json", summary: "Re-run the URLs of a run report with the flags and file names of the run, into a new output directory, without its webhooks, -review, tickets, -elasticURL or -flakinessFile.", run: runReplay},
+		{name: "export", args: "state.json", summary: "Bundle the -stateFile checkpoint, baseline manifest and config.yaml fingerprint of a run, to resume it on another machine.", run: runExport},
+		{name: "import", args: "state.json", summary: "Restore a run exported with export, for capture -resume.", run: runImport},
+		{name: "decrypt", args: "files...", summary: "Decrypt screenshots and reports written with -encrypt.", run: runDecrypt},
+	}
+}
+
+// Flags only diff takes.
+var (
+	baselineDir = new(string)
+	bootstrap   = new(bool)
+)
+
+// The URL of a quick capture, and the flags only it takes.
+var (
+	quickURL     = new(string)
+	quickOutput  = new(string)
+	quickStdout  = new(bool)
+	quickDataURL = new(bool)
+	quickCopy    = new(bool)
+)
+
+// commandLine is the command and arguments of a capture run, kept in its
+// report for replay.
+var commandLine []string
+
+// secretFlags hold values that must not be recorded with the command line.
+var secretFlags = map[string]bool{"inputHeader": true}
+
+// maskArgs returns args with the values of -inputHeader masked, keeping the
+// header names, and the credentials of URLs left out, in flag values and
+// comma-separated lists too.
+func maskArgs(args []string) []string {
+	masked := make([]string, len(args))
+	secret := false
+	for i, arg := range args {
+		switch {
+		case secret:
+			arg, secret = maskHeader(arg), false
+		case strings.HasPrefix(arg, "-"):
+			name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			switch {
+			case secretFlags[name] && !hasValue:
+				secret = true
+			case secretFlags[name]:
+				arg = arg[:len(arg)-len(value)] + maskHeader(value)
+			case hasValue:
+				arg = arg[:len(arg)-len(value)] + maskURLs(value)
+			}
+		default:
+			arg = maskURLs(arg)
+		}
+		masked[i] = arg
+	}
+	return masked
+}
+
+// maskURLs masks the userinfo of the URLs of a comma-separated list: the
+// password when there is one, else the user name, which is then the token.
+func maskURLs(list string) string {
+	parts := strings.Split(list, ",")
+	for i, p := range parts {
+		u, err := url.Parse(p)
+		if err != nil || u.User == nil {
+			continue
+		}
+		if _, ok := u.User.Password(); ok {
+			parts[i] = u.Redacted()
+		} else {
+			u.User = url.User("xxxxx")
+			parts[i] = u.String()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// maskHeader keeps the name of a "Name: value" header.
+func maskHeader(h string) string {
+	name, _, _ := strings.Cut(h, ":")
+	return name + ": xxxxx"
+}
+
+func main() {
+	name, args := "capture", os.Args[1:]
+	// a URL is the quick capture of the default command
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") && !strings.Contains(args[0], "://") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		if len(args) == 0 {
+			printUsage()
+			return
+		}
+		name, args = args[0], []string{"-h"}
+	}
+
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+	if err := c.run(c, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: screenshoter [command] [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun screenshoter help <command> for the flags of a command.\n")
+}
+
+// flagSet returns the flags of c, with the shared capture flags when shared
+// is set, and a help message listing them.
+func (c *command) flagSet(shared bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	if shared {
+		flag.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+	}
+	fs.Usage = func() {
+		synopsis := strings.TrimSpace(fmt.Sprintf("screenshoter %s [flags] %s", c.name, c.args))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\nFlags:\n", synopsis, c.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func captureCommand(mode captureMode) func(c *command, args []string) error {
+	return func(c *command, args []string) error {
+		if err := parseCaptureFlags(c, mode, args); err != nil {
+			return err
+		}
+		return runCapture(mode, nil)
+	}
+}
+
+// parseCaptureFlags sets the capture flags of mode from args.
+func parseCaptureFlags(c *command, mode captureMode, args []string) error {
+	commandLine = append([]string{c.name}, maskArgs(args)...)
+	fs := c.flagSet(true)
+	if mode == modeDiff {
+		fs.StringVar(baselineDir, "baselineDir", "baselines", "Directory of the baseline screenshots and manifest")
+		fs.BoolVar(bootstrap, "bootstrap", false, "Capture the URL list as a new baseline instead of comparing against it")
+	}
+	if mode == modeCapture {
+		fs.StringVar(quickOutput, "o", "", "File a quick capture of the URL given is saved to, e.g. shot.png; the extension picks the format")
+		fs.BoolVar(quickStdout, "stdout", false, "Write the image of a quick capture to stdout instead of its path")
+		fs.BoolVar(quickDataURL, "dataURL", false, "Print the image of a quick capture as a base64 data: URL instead of its path")
+		fs.BoolVar(quickCopy, "copy", false, "Copy the image of a quick capture to the clipboard, with osascript on macOS, wl-copy or xclip on Linux and PowerShell on Windows")
+	}
+	_ = fs.Parse(args)
+	// flags may follow the URL of a quick capture
+	var rest []string
+	for fs.NArg() > 0 && mode == modeCapture {
+		rest = append(rest, fs.Arg(0))
+		_ = fs.Parse(fs.Args()[1:])
+	}
+	if fs.NArg() > 0 || len(rest) > 1 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(append(rest, fs.Args()...), " "))
+	}
+	if len(rest) == 1 {
+		*quickURL = rest[0]
+	} else if *quickOutput != "" || *quickStdout || *quickDataURL || *quickCopy {
+		return fmt.Errorf("-o, -stdout, -dataURL and -copy need the URL to capture")
+	}
+
+	return nil
+}
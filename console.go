@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// consoleWriter is the terminal side of the logger. It colors each log line
+// by what it reports while the log file keeps receiving plain text.
+type consoleWriter struct {
+	out   io.Writer
+	color bool
+}
+
+func newConsoleWriter(out *os.File, mode string) *consoleWriter {
+	return &consoleWriter{out: out, color: useColor(out, mode)}
+}
+
+// useColor honours -color=always|never, and in auto mode disables colors when
+// NO_COLOR is set (https://no-color.org) or the output is not a terminal.
+func useColor(out *os.File, mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	stat, err := out.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (w *consoleWriter) Write(p []byte) (int, error) {
+	code := lineColor(p)
+	if !w.color || code == "" {
+		return w.out.Write(p)
+	}
+
+	line := bytes.TrimRight(p, "\n")
+	buf := make([]byte, 0, len(line)+len(code)+len(ansiReset)+1)
+	buf = append(buf, code...)
+	buf = append(buf, line...)
+	buf = append(buf, ansiReset+"\n"...)
+	if _, err := w.out.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineColors color log lines by the start of their message, after the
+// date and time of log.LstdFlags, so that a URL or file name that happens to
+// contain "error" doesn't change the color of its line.
+var lineColors = []struct {
+	message *regexp.Regexp
+	color   string
+}{
+	{logMessage(`(retrying|not retrying|waiting for) `), ansiYellow},
+	{logMessage(`(failed to |can't |invalid |aborting |server \S+ is not available)`), ansiRed},
+	{logMessage(`(saved file |screenshot taker server \S+ is available)`), ansiGreen},
+}
+
+func logMessage(prefix string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?:\d{4}/\d\d/\d\d \d\d:\d\d:\d\d )?` + prefix)
+}
+
+func lineColor(line []byte) string {
+	for _, c := range lineColors {
+		if c.message.Match(line) {
+			return c.color
+		}
+	}
+	return ""
+}
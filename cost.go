@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+)
+
+// defaultImageKB is the image size -estimate assumes when config.yaml
+// doesn't give one.
+const defaultImageKB = 300
+
+// pricing is the pricing section of config.yaml: what the rendering API
+// charges per capture and the output per GB stored, for -estimate.
+type pricing struct {
+	Currency   string  `yaml:"currency"`
+	PerCapture float64 `yaml:"perCapture"`
+	PerGB      float64 `yaml:"perGB"`
+	// AverageImageKB is the expected size of an image, for estimating the
+	// storage before any is taken.
+	AverageImageKB float64 `yaml:"averageImageKB"`
+}
+
+// actualCost logs and returns what the run cost, from its usage: retries
+// and -warmup renders count as captures.
+func actualCost(u *usage, p pricing, logger *log.Logger) float64 {
+	u.mu.Lock()
+	captures, bytes := u.captures, u.bytes
+	u.mu.Unlock()
+
+	cost := p.cost(captures, bytes)
+	logger.Printf("cost: %d captures, %d bytes: %s", captures, bytes, p.format(cost))
+	return cost
+}
+
+func (p pricing) cost(captures, bytes int64) float64 {
+	return float64(captures)*p.PerCapture + float64(bytes)/(1<<30)*p.PerGB
+}
+
+func (p pricing) format(cost float64) string {
+	currency := p.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	return fmt.Sprintf("%.2f %s", cost, currency)
+}
+
+// estimateCost reads the input named by opt.inputFilePath a first time to
+// count its URLs, and returns the cost of taking every shot of each, with
+// the images of AverageImageKB. Only the URLs of the -shard are counted, and
+// pages only -crawl would find aren't.
+func estimateCost(opt *runOptions, p pricing, opts inputOptions, logger *log.Logger) (float64, error) {
+	if p.PerCapture == 0 && p.PerGB == 0 {
+		logger.Printf("config.yaml has no pricing section, every cost is 0")
+	}
+
+	opts.cache, opts.follow = nil, false
+	jobs, err := openJobs(context.Background(), opt.inputFilePath, opts)
+	if err != nil {
+		return 0, err
+	}
+	if closer, ok := jobs.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if opt.shard != nil {
+		jobs = &shardSource{jobs: jobs, shard: opt.shard}
+	}
+	if opt.filter != nil {
+		jobs = &filteredSource{jobs: jobs, filter: opt.filter}
+	}
+	if opt.window.enabled() {
+		jobs = &windowSource{jobs: jobs, window: opt.window}
+	}
+
+	urls := int64(0)
+	for {
+		j, err := jobs.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && j.url == "" {
+			return 0, err
+		}
+		urls++
+	}
+
+	kb := p.AverageImageKB
+	if kb <= 0 {
+		kb = defaultImageKB
+	}
+	captures := urls * int64(len(opt.shots("")))
+	cost := p.cost(captures, int64(float64(captures)*kb*1024))
+	logger.Printf("estimate: %d URLs, %d captures of about %.0f KB: %s", urls, captures, kb, p.format(cost))
+	return cost, nil
+}
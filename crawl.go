@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// crawlPage is a page waiting to be captured by -crawl, depth links away
+// from its seed.
+type crawlPage struct {
+	job   job
+	depth int
+	seed  *url.URL
+}
+
+// crawlSource captures the seed URLs of its input and the pages of their
+// origin reachable from them in at most depth links, breadth first and each
+// once. Pages are fetched directly to find their links, like -checkLinks
+// does, so links added by scripts aren't followed. Discovered pages that
+// aren't HTML are skipped; they take their seed's options but not its file
+// name.
+type crawlSource struct {
+	seeds   jobSource
+	client  *http.Client
+	depth   int
+	queue   []crawlPage
+	visited map[string]bool
+}
+
+func newCrawlSource(seeds jobSource, depth int) *crawlSource {
+	return &crawlSource{seeds: seeds, client: &http.Client{Timeout: 30 * time.Second}, depth: depth, visited: map[string]bool{}}
+}
+
+func (c *crawlSource) next() (job, error) {
+	for {
+		if len(c.queue) == 0 {
+			j, err := c.seeds.next()
+			if err != nil {
+				return j, err
+			}
+			seed, perr := url.Parse(j.url)
+			if perr != nil || c.visited[crawlKey(seed)] {
+				continue
+			}
+			c.visited[crawlKey(seed)] = true
+			c.queue = append(c.queue, crawlPage{job: j, seed: seed})
+		}
+
+		page := c.queue[0]
+		c.queue = c.queue[1:]
+		if page.depth == 0 && c.depth == 0 {
+			return page.job, nil
+		}
+
+		links, final, isHTML := c.fetch(page.job.url)
+		if page.depth > 0 && !isHTML {
+			continue
+		}
+		if page.depth == 0 && final != nil {
+			// a seed redirecting to www. or https sets the origin crawled
+			page.seed = final
+			c.visited[crawlKey(final)] = true
+		}
+		if page.depth < c.depth {
+			for _, l := range links {
+				u, err := url.Parse(l)
+				if err != nil || u.Scheme != page.seed.Scheme || u.Host != page.seed.Host || c.visited[crawlKey(u)] {
+					continue
+				}
+				c.visited[crawlKey(u)] = true
+				found := job{url: l, options: page.job.options}
+				found.options.fileName = ""
+				c.queue = append(c.queue, crawlPage{job: found, depth: page.depth + 1, seed: page.seed})
+			}
+		}
+		return page.job, nil
+	}
+}
+
+// fetch returns the links of the page at u, its URL after redirects and
+// whether it is HTML. Pages that fail to load have no links, and are
+// captured anyway so the failure shows in the results.
+func (c *crawlSource) fetch(u string) ([]string, *url.URL, bool) {
+	resp, err := c.client.Get(u)
+	if err != nil {
+		return nil, nil, true
+	}
+
+	defer resp.Body.Close()
+
+	media, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if media != "" && media != "text/html" && media != "application/xhtml+xml" {
+		return nil, resp.Request.URL, false
+	}
+	if resp.StatusCode > 299 {
+		return nil, resp.Request.URL, true
+	}
+	links, _ := pageReferences(io.LimitReader(resp.Body, maxPageSize), resp.Request.URL)
+	return links, resp.Request.URL, true
+}
+
+// crawlKey identifies a page for the visited set: the URL without its
+// fragment, which pageReferences already drops from links.
+func crawlKey(u *url.URL) string {
+	k := *u
+	k.Fragment = ""
+	return k.String()
+}
+
+func (c *crawlSource) Close() error {
+	if closer, ok := c.seeds.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
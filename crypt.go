@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted files are a magic header and random nonce prefix followed by
+// AES-256-GCM sealed chunks. Each chunk's nonce is the prefix plus a counter,
+// and the last chunk is authenticated as final, so chunks can't be reordered,
+// dropped or truncated without decryption failing.
+const (
+	encMagic       = "SSENC1"
+	encPrefixSize  = 8
+	encChunkSize   = 64 * 1024
+	encryptedExt   = ".enc"
+	encContentType = "application/octet-stream"
+)
+
+var errCorrupted = errors.New("encrypted data is corrupted or the key is wrong")
+
+// readKey loads a 32-byte key stored hex-encoded in keyFile, e.g. one made
+// with `openssl rand -hex 32`.
+func readKey(keyFile string) (cipher.AEAD, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must contain a hex-encoded 32-byte key", keyFile)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encPrefixSize:], counter)
+	return nonce
+}
+
+func finalFlag(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func encryptStream(w io.Writer, r io.Reader, aead cipher.AEAD) error {
+	prefix := make([]byte, encPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte(encMagic), prefix...)); err != nil {
+		return err
+	}
+
+	// read one chunk ahead so the last chunk can be marked final
+	cur := make([]byte, encChunkSize)
+	next := make([]byte, encChunkSize)
+	n, err := io.ReadFull(r, cur)
+	for counter := uint32(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		final := err != nil
+		var m int
+		if !final {
+			m, err = io.ReadFull(r, next)
+			final = err == io.EOF
+		}
+
+		sealed := aead.Seal(nil, chunkNonce(prefix, counter), cur[:n], finalFlag(final))
+		if _, werr := w.Write(sealed); werr != nil {
+			return werr
+		}
+		if final {
+			return nil
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+func decryptStream(w io.Writer, r io.Reader, aead cipher.AEAD) error {
+	header := make([]byte, len(encMagic)+encPrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.HasPrefix(header, []byte(encMagic)) {
+		return fmt.Errorf("not a screenshoter encrypted file")
+	}
+	prefix := header[len(encMagic):]
+
+	sealedSize := encChunkSize + aead.Overhead()
+	cur := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+	n, err := io.ReadFull(r, cur)
+	for counter := uint32(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		final := err != nil
+		var m int
+		if !final {
+			m, err = io.ReadFull(r, next)
+			final = err == io.EOF
+		}
+
+		plain, oerr := aead.Open(nil, chunkNonce(prefix, counter), cur[:n], finalFlag(final))
+		if oerr != nil {
+			return errCorrupted
+		}
+		if _, werr := w.Write(plain); werr != nil {
+			return werr
+		}
+		if final {
+			return nil
+		}
+		cur, next, n = next, cur, m
+	}
+}
+
+// encryptingReader encrypts r on the fly for a storage backend to consume.
+// The returned closer must be called once the backend is done with it.
+func encryptingReader(r io.Reader, aead cipher.AEAD) (io.Reader, io.Closer) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptStream(pw, r, aead))
+	}()
+	return pr, pr
+}
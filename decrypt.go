@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runDecrypt implements `screenshoter decrypt -key <file> [-outputDir dir] files...`.
+func runDecrypt(c *command, args []string) error {
+	fs := c.flagSet(false)
+	keyFile := fs.String("key", "", "File with the hex-encoded 32-byte key used with -encrypt")
+	outDir := fs.String("outputDir", "", "Directory to write decrypted files to (defaults to next to the input)")
+	_ = fs.Parse(args)
+
+	if *keyFile == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: decrypt -key <file> [-outputDir dir] files...")
+	}
+
+	aead, err := readKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	for _, src := range fs.Args() {
+		dst := strings.TrimSuffix(src, encryptedExt)
+		if dst == src {
+			dst = src + ".dec"
+		}
+		if *outDir != "" {
+			dst = filepath.Join(*outDir, filepath.Base(dst))
+		}
+
+		if err := decryptFile(src, dst, aead); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		fmt.Println(dst)
+	}
+	return nil
+}
+
+func decryptFile(src, dst string, aead cipher.AEAD) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if err = decryptStream(out, in, aead); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
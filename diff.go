@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// pixelTolerance is the largest per-channel difference (out of 255) at which
+// two pixels still count as equal, so JPEG noise and antialiasing don't show
+// up as changes.
+const pixelTolerance = 16
+
+var diffColor = color.RGBA{R: 255, A: 255}
+
+// imageDiff is the outcome of comparing two screenshots pixel by pixel.
+type imageDiff struct {
+	differing int
+	total     int
+	// image shows the first screenshot faded, with differing pixels in red.
+	image *image.RGBA
+}
+
+func (d imageDiff) percent() float64 {
+	if d.total == 0 {
+		return 0
+	}
+	return float64(d.differing) * 100 / float64(d.total)
+}
+
+// diffImages compares a and b over the union of their sizes; pixels that only
+// one of them has count as differing.
+func diffImages(a, b image.Image) imageDiff {
+	ab, bb := a.Bounds(), b.Bounds()
+	w, h := max(ab.Dx(), bb.Dx()), max(ab.Dy(), bb.Dy())
+
+	d := imageDiff{total: w * h, image: image.NewRGBA(image.Rect(0, 0, w, h))}
+	draw.Draw(d.image, d.image.Bounds(), image.White, image.Point{}, draw.Src)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pa, inA := pixelAt(a, x, y)
+			pb, inB := pixelAt(b, x, y)
+			if inA && inB && samePixel(pa, pb) {
+				d.image.SetRGBA(x, y, faded(pa))
+				continue
+			}
+			d.differing++
+			d.image.SetRGBA(x, y, diffColor)
+		}
+	}
+	return d
+}
+
+func pixelAt(img image.Image, x, y int) (color.RGBA, bool) {
+	b := img.Bounds()
+	if x >= b.Dx() || y >= b.Dy() {
+		return color.RGBA{}, false
+	}
+	return color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA), true
+}
+
+func samePixel(a, b color.RGBA) bool {
+	return channelDelta(a.R, b.R) <= pixelTolerance &&
+		channelDelta(a.G, b.G) <= pixelTolerance &&
+		channelDelta(a.B, b.B) <= pixelTolerance &&
+		channelDelta(a.A, b.A) <= pixelTolerance
+}
+
+func channelDelta(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// faded turns an unchanged pixel into light grey so the changes stand out.
+func faded(c color.RGBA) color.RGBA {
+	grey := uint8((uint16(c.R)*3 + uint16(c.G)*6 + uint16(c.B)) / 10)
+	grey = 255 - (255-grey)/4
+	return color.RGBA{R: grey, G: grey, B: grey, A: 255}
+}
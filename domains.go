@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// domainOverride adjusts captures of URLs whose host matches Match, a glob
+// such as "*.example.com". The first matching entry in config.yaml wins.
+type domainOverride struct {
+	Match    string            `yaml:"match"`
+	Delay    *int              `yaml:"delay"`
+	Width    int               `yaml:"width"`
+	Height   int               `yaml:"height"`
+	Headers  map[string]string `yaml:"headers"`
+	Cookies  map[string]string `yaml:"cookies"`
+	Interval time.Duration     `yaml:"interval"`
+}
+
+func hostOf(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+func (conf *config) domainFor(u string) *domainOverride {
+	host := hostOf(u)
+	for i := range conf.Domains {
+		d := &conf.Domains[i]
+		if ok, _ := path.Match(strings.ToLower(d.Match), host); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+func (d *domainOverride) apply(o captureOptions) captureOptions {
+	if d.Delay != nil {
+		o.delay = *d.Delay
+	}
+	if d.Width > 0 {
+		o.width = d.Width
+	}
+	if d.Height > 0 {
+		o.height = d.Height
+	}
+	if len(d.Headers) > 0 {
+		o.headers = d.Headers
+	}
+	if len(d.Cookies) > 0 {
+		o.cookies = d.Cookies
+	}
+	return o
+}
+
+// politeness spaces out requests to the same host by the interval configured
+// for its domain.
+type politeness struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func (p *politeness) wait(host string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	if p.next == nil {
+		p.next = map[string]time.Time{}
+	}
+	now := time.Now()
+	at := p.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	p.next[host] = at.Add(interval)
+	p.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// throttle waits until a request to u is due under the interval of its
+// domain and -hostRate.
+func (runOptions *runOptions) throttle(ctx context.Context, u string) error {
+	if d := runOptions.server.domainFor(u); d != nil {
+		runOptions.politeness.wait(hostOf(u), d.Interval)
+	}
+	return runOptions.hostLimiter.wait(ctx, hostOf(u))
+}
+
+// hostLimiter keeps the requests to every host under -hostRate per second
+// with a token bucket per host, however many captures run at once, so that
+// a list of many pages of one site doesn't trip its firewall.
+type hostLimiter struct {
+	limit   rate.Limit
+	burst   int
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+func newHostLimiter(perSecond float64, burst int) *hostLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &hostLimiter{limit: rate.Limit(perSecond), burst: burst, buckets: map[string]*rate.Limiter{}}
+}
+
+// wait blocks until a request may be sent to host, or ctx is done.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	bucket, ok := l.buckets[host]
+	if !ok {
+		bucket = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[host] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.Wait(ctx)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// elasticSink indexes one document per capture into Elasticsearch/OpenSearch.
+// Authentication is taken from ELASTIC_API_KEY or ELASTIC_USERNAME/ELASTIC_PASSWORD.
+type elasticSink struct {
+	endpoint string
+	apiKey   string
+	username string
+	password string
+	client   *http.Client
+	redactor redactor
+}
+
+func newElasticSink(baseURL, index string, r redactor) *elasticSink {
+	return &elasticSink{
+		endpoint: fmt.Sprintf("%s/%s/_doc", strings.TrimRight(baseURL, "/"), index),
+		apiKey:   os.Getenv("ELASTIC_API_KEY"),
+		username: os.Getenv("ELASTIC_USERNAME"),
+		password: os.Getenv("ELASTIC_PASSWORD"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		redactor: r,
+	}
+}
+
+func (s *elasticSink) record(res *captureResult) error {
+	doc, err := json.Marshal(s.redactor.result(res))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(doc))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("elasticsearch returned %s: %s", resp.Status, msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// environment is one deployment of a site, e.g. prod or staging. With
+// -compareEnvironments every input line is a path captured against the base
+// URL of each environment.
+type environment struct {
+	Name    string `yaml:"name"`
+	BaseURL string `yaml:"baseURL"`
+}
+
+// checkEnvironments validates the environments section of config.yaml.
+func checkEnvironments(envs []environment) error {
+	if len(envs) < 2 {
+		return fmt.Errorf("-compareEnvironments needs at least two environments in config.yaml")
+	}
+
+	seen := map[string]bool{}
+	for _, e := range envs {
+		if e.Name == "" || strings.ContainsAny(e.Name, "/\\ ") {
+			return fmt.Errorf("environment name %q must be non-empty and contain no slashes or spaces", e.Name)
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("environment %q is defined twice", e.Name)
+		}
+		seen[e.Name] = true
+
+		u, err := url.Parse(e.BaseURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("environment %s needs an http(s) baseURL, got %q", e.Name, e.BaseURL)
+		}
+	}
+	return nil
+}
+
+// url returns the page at path in e. An input line that is a full URL keeps
+// its path and query but moves to e's host.
+func (e *environment) url(path string) string {
+	if u, err := url.Parse(path); err == nil && u.Host != "" {
+		path = u.RequestURI()
+		if u.Fragment != "" {
+			path += "#" + u.Fragment
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(e.BaseURL, "/") + path
+}
+
+// comparison is the pixel difference between the same shot of a path taken
+// in two environments, or between a shot and its baseline with `diff`.
+type comparison struct {
+	Path string `json:"path"`
+	// Shot is the file name suffix of the shot, for -capturePositions and
+	// -frameSelector; empty for a plain page capture.
+	Shot        string  `json:"shot,omitempty"`
+	A           string  `json:"a"`
+	B           string  `json:"b"`
+	DiffPercent float64 `json:"diffPercent"`
+	Differs     bool    `json:"differs"`
+	// New is set by `diff` for shots without a baseline.
+	New bool `json:"new,omitempty"`
+	// DiffFile highlights the differing pixels; it is stored for pairs that
+	// differ by more than -diffThreshold.
+	DiffFile string `json:"diffFile,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Ticket is the issue opened for the change, see ticketConfig.
+	Ticket string `json:"ticket,omitempty"`
+
+	// diffName is DiffFile relative to the output, for linking to it.
+	diffName string
+}
+
+// comparisonSink is implemented by result sinks that also collect the
+// outcome of -compareEnvironments.
+type comparisonSink interface {
+	compared(c *comparison)
+}
+
+func (r *runReport) compared(c *comparison) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.Differs {
+		r.Differences++
+	}
+	r.Comparisons = append(r.Comparisons, c)
+}
+
+// decodeShot loads a spooled screenshot for comparison.
+func decodeShot(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// compareEnvironments diffs the shots of j pairwise between environments. The
+// shots of one environment follow each other in the order of config.yaml,
+// so each run of len(environments) shots is one shot in every environment.
+func compareEnvironments(runOptions *runOptions, j job, shots []shot, results []*captureResult, logger *log.Logger) {
+	n := len(runOptions.environments)
+	for start := 0; start+n <= len(shots); start += n {
+		for a := start; a < start+n; a++ {
+			for b := a + 1; b < start+n; b++ {
+				c := compareShots(runOptions, j, shots[a], shots[b], results[a], results[b], logger)
+				switch {
+				case c.Error != "":
+					logger.Printf("can't compare %s between %s and %s: %s", runOptions.value(j.url), c.A, c.B, runOptions.within(c.Error, j.url))
+				case c.Differs:
+					logger.Printf("%s%s differs between %s and %s: %.2f%% of pixels", runOptions.value(j.url), c.Shot, c.A, c.B, c.DiffPercent)
+				}
+				for _, sink := range runOptions.sinks {
+					if cs, ok := sink.(comparisonSink); ok {
+						cs.compared(c)
+					}
+				}
+			}
+		}
+	}
+
+	for _, res := range results {
+		res.image = nil
+	}
+}
+
+func compareShots(runOptions *runOptions, j job, sa, sb shot, ra, rb *captureResult, logger *log.Logger) *comparison {
+	c := &comparison{Path: j.url, A: sa.env.Name, B: sb.env.Name}
+	plain := sa
+	plain.env = nil
+	c.Shot = plain.suffix()
+
+	for _, missing := range []struct {
+		env string
+		res *captureResult
+	}{{c.A, ra}, {c.B, rb}} {
+		if missing.res.image == nil {
+			c.Error = fmt.Sprintf("no screenshot from %s", missing.env)
+			return c
+		}
+	}
+
+	d := diffImages(ra.image, rb.image)
+	c.DiffPercent = d.percent()
+	c.Differs = d.differing > 0 && c.DiffPercent > runOptions.diffThreshold
+	if !c.Differs {
+		return c
+	}
+
+	name, err := outputFileName(runOptions, ra.URL, plain, j.options, "png")
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	name = strings.TrimSuffix(name, ".png") + fmt.Sprintf("-%s-vs-%s-diff.png", c.A, c.B)
+
+	c.diffName = name
+	if c.DiffFile, err = storeDiff(runOptions, name, ra.URL, d); err != nil {
+		c.Error = err.Error()
+	}
+	runOptions.tickets.file(runOptions, c, ra.image, rb.image, d, logger)
+	return c
+}
+
+// storeDiff puts the highlighted difference image into the output.
+func storeDiff(runOptions *runOptions, name, u string, d imageDiff) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, d.image); err != nil {
+		return "", err
+	}
+	location, _, err := store(runOptions, name, &buf, map[string]string{"content-type": "image/png", "source-url": u})
+	if err != nil {
+		return "", fmt.Errorf("can't store %s: %v", name, err)
+	}
+	return location, nil
+}
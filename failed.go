@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// failedList writes every URL that failed to a file in the input format, with
+// the reason as a comment above it, so the failures can be re-run with -file.
+// The file is written next to its final path and only moved there when the
+// run ends, so the failures of a previous run can be re-run from it.
+type failedList struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	seen map[string]bool
+}
+
+func newFailedList(path string) *failedList {
+	return &failedList{path: path, seen: map[string]bool{}}
+}
+
+func (l *failedList) record(res *captureResult) error {
+	if res.Status != statusFailed {
+		return nil
+	}
+
+	// re-run what was in the input, not what it was rewritten to
+	u := res.URL
+	if res.OriginalURL != "" {
+		u = res.OriginalURL
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[u] {
+		return nil
+	}
+	l.seen[u] = true
+
+	if err := l.open(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(l.f, "# %s\n%s\n", res.Error, u)
+	return err
+}
+
+func (l *failedList) open() error {
+	if l.f != nil {
+		return nil
+	}
+	f, err := os.Create(l.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	l.f = f
+	return nil
+}
+
+// Close finishes the file. When nothing failed it is left empty, so that
+// re-running it doesn't capture again the URLs that pass now.
+func (l *failedList) Close() error {
+	if err := l.open(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(l.f.Name(), l.path)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eubelov/screenshoter/screenshoter"
+)
+
+// captureOptions are the render settings of a single attempt.
+type captureOptions struct {
+	width      int
+	height     int
+	delay      int
+	format     string
+	disableJS  bool
+	headers    map[string]string
+	cookies    map[string]string
+	style      string
+	script     string
+	initScript string
+
+	waitForFunction string
+	waitForTimeout  time.Duration
+
+	// scrollPercent is the scroll offset to capture at, or -1 for the top
+	// of the page without scrolling.
+	scrollPercent int
+	frameSelector string
+	proxy         string
+	// params are extra action parameters given by the input.
+	params map[string]string
+}
+
+// library translates the options for the screenshoter package, which builds
+// the render requests.
+func (o captureOptions) library(fileName string) screenshoter.CaptureOptions {
+	lib := screenshoter.CaptureOptions{
+		Width:             o.width,
+		Height:            o.height,
+		Delay:             time.Duration(o.delay) * time.Second,
+		Format:            o.format,
+		FileName:          fileName,
+		DisableJavaScript: o.disableJS,
+		Headers:           o.headers,
+		Cookies:           o.cookies,
+		Style:             o.style,
+		Script:            o.script,
+		InitScript:        o.initScript,
+		WaitForFunction:   o.waitForFunction,
+		WaitForTimeout:    o.waitForTimeout,
+		FrameSelector:     o.frameSelector,
+		Proxy:             o.proxy,
+		Extra:             o.params,
+	}
+	if o.scrollPercent >= 0 {
+		scroll := o.scrollPercent
+		lib.ScrollPercent = &scroll
+	}
+	return lib
+}
+
+// captureOptions returns the options for u: the command line settings with
+// any matching per-domain override from config.yaml applied.
+func (runOptions *runOptions) captureOptions(u string) captureOptions {
+	opts := captureOptions{
+		width:  runOptions.width,
+		height: runOptions.height,
+		delay:  runOptions.delay,
+		format: runOptions.format,
+
+		waitForFunction: runOptions.waitForFunction,
+		waitForTimeout:  runOptions.waitForTimeout,
+		scrollPercent:   -1,
+	}
+	opts.style, opts.script, opts.initScript = runOptions.injections()
+	opts.style = joinSnippets(opts.style, runOptions.masks.style(u))
+	if d := runOptions.server.domainFor(u); d != nil {
+		opts = d.apply(opts)
+	}
+	return opts
+}
+
+// fallbackStep relaxes the options of a capture that keeps failing. Steps are
+// cumulative: the third attempt has the first two steps applied.
+type fallbackStep struct {
+	name  string
+	param string
+	apply func(o captureOptions) captureOptions
+}
+
+var fallbackSteps = map[string]fallbackStep{
+	"delay": {name: "delay", apply: func(o captureOptions) captureOptions {
+		o.delay = o.delay*2 + 5
+		return o
+	}},
+	"viewport": {name: "viewport", apply: func(o captureOptions) captureOptions {
+		o.width, o.height = max(o.width/2, 320), max(o.height/2, 240)
+		return o
+	}},
+	"jpeg": {name: "jpeg", apply: func(o captureOptions) captureOptions {
+		o.format = "jpeg"
+		return o
+	}},
+	"nojs": {name: "nojs", param: "DisableJavaScript", apply: func(o captureOptions) captureOptions {
+		o.disableJS = true
+		return o
+	}},
+}
+
+func parseFallbacks(value string) ([]fallbackStep, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var steps []fallbackStep
+	for _, name := range strings.Split(value, ",") {
+		step, ok := fallbackSteps[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown fallback %q (use delay, viewport, jpeg or nojs)", name)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// attempt is one try of a capture: the options to use and the fallbacks that
+// produced them.
+type attempt struct {
+	options   captureOptions
+	fallbacks []string
+}
+
+// attempts returns the original options followed by one attempt per
+// configured fallback step.
+func (runOptions *runOptions) attempts(base captureOptions) []attempt {
+	list := []attempt{{options: base}}
+	for _, step := range runOptions.fallbacks {
+		prev := list[len(list)-1]
+		list = append(list, attempt{
+			options:   step.apply(prev.options),
+			fallbacks: append(append([]string(nil), prev.fallbacks...), step.name),
+		})
+	}
+	return list
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedDoc covers RSS 2.0 (items in a channel), RSS 1.0 (items next to it)
+// and Atom (entries).
+type feedDoc struct {
+	Channel struct {
+		feedItem
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+	feedItem
+	Items   []feedItem `xml:"item"`
+	Entries []feedItem `xml:"entry"`
+}
+
+type feedItem struct {
+	Links     []feedLink `xml:"link"`
+	PubDate   string     `xml:"pubDate"`
+	Date      string     `xml:"date"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+// feedLink is the text of an RSS link or the href of an Atom one.
+type feedLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Text string `xml:",chardata"`
+}
+
+func (it feedItem) link() string {
+	for _, l := range it.Links {
+		if text := strings.TrimSpace(l.Text); text != "" {
+			return text
+		}
+		if l.Href != "" && (l.Rel == "" || l.Rel == "alternate") {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	return ""
+}
+
+var feedDateLayouts = []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2006-01-02"}
+
+// published returns when the item was published or last updated, the zero
+// time when the feed doesn't say.
+func (it feedItem) published() time.Time {
+	for _, v := range []string{it.PubDate, it.Published, it.Updated, it.Date} {
+		v = strings.TrimSpace(v)
+		for _, layout := range feedDateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// feedSource yields the links of the items of an RSS or Atom feed, newest
+// first when every item is dated and in feed order otherwise.
+type feedSource struct {
+	jobs []job
+}
+
+// newFeedSource reads the feed whose root element dec just returned,
+// keeping the newest limit items, or all of them when limit is 0. Relative
+// links are resolved against the feed's URL, or the site it links to when
+// it was read from a file.
+func newFeedSource(name string, dec *xml.Decoder, root xml.StartElement, limit int) (*feedSource, error) {
+	var doc feedDoc
+	if err := dec.DecodeElement(&doc, &root); err != nil {
+		return nil, err
+	}
+
+	items := append(append(doc.Channel.Items, doc.Items...), doc.Entries...)
+	dated := true
+	for _, it := range items {
+		dated = dated && !it.published().IsZero()
+	}
+	if dated {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].published().After(items[j].published()) })
+	}
+
+	base, _ := url.Parse(name)
+	if base == nil || !base.IsAbs() {
+		site := doc.link()
+		if site == "" {
+			site = doc.Channel.link()
+		}
+		base, _ = url.Parse(site)
+	}
+	var jobs []job
+	for i, it := range items {
+		if limit > 0 && len(jobs) == limit {
+			break
+		}
+		link := it.link()
+		if link == "" {
+			continue
+		}
+		j := job{url: link, line: i + 1}
+		if u, err := url.Parse(link); err == nil && !u.IsAbs() && base != nil && base.IsAbs() {
+			j.url = base.ResolveReference(u).String()
+		}
+		jobs = append(jobs, j)
+	}
+	return &feedSource{jobs: jobs}, nil
+}
+
+func (fs *feedSource) next() (job, error) {
+	if len(fs.jobs) == 0 {
+		return job{}, io.EOF
+	}
+	j := fs.jobs[0]
+	fs.jobs = fs.jobs[1:]
+	if _, err := url.Parse(j.url); err != nil {
+		return j, fmt.Errorf("item %d: %w", j.line, err)
+	}
+	return j, nil
+}
+
+// openXML reads an XML input as a feed or sitemap, by its root element.
+func openXML(ctx context.Context, name string, rc io.ReadCloser, opts inputOptions) (jobSource, error) {
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			rc.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("no root element")
+			}
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		root, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch root.Name.Local {
+		case "rss", "RDF", "feed":
+			defer rc.Close()
+			fs, err := newFeedSource(name, dec, root, opts.feedItems)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			return fs, nil
+		}
+		return newSitemapSource(ctx, name, rc, dec, opts), nil
+	}
+}
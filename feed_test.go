@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func readURLs(t *testing.T, name string, opts inputOptions) []string {
+	t.Helper()
+	src, err := openJobs(context.Background(), name, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer, ok := src.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var urls []string
+	for {
+		j, err := src.next()
+		if err == io.EOF {
+			return urls
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		urls = append(urls, j.url)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	tests := []struct {
+		name string
+		opts inputOptions
+		want []string
+	}{
+		// newest first; the edit and replies links aren't the entry's page,
+		// and an entry with no page is left out
+		{"testdata/feed.atom", inputOptions{}, []string{"https://example.com/posts/newer", "https://example.com/posts/older"}},
+		// dates in several layouts and zones, relative links resolved
+		// against the site
+		{"testdata/feed.rss", inputOptions{}, []string{"https://example.com/blog/c", "https://example.com/blog/b", "https://example.com/blog/a"}},
+		{"testdata/feed.rss", inputOptions{feedItems: 2}, []string{"https://example.com/blog/c", "https://example.com/blog/b"}},
+		// one undated item keeps the feed order
+		{"testdata/feed-undated.rss", inputOptions{}, []string{"https://example.com/first", "https://example.com/second", "https://example.com/third"}},
+		{"testdata/feed-undated.rss", inputOptions{feedItems: 1}, []string{"https://example.com/first"}},
+		{"testdata/feed-rdf.xml", inputOptions{}, []string{"https://example.com/two", "https://example.com/one"}},
+	}
+	for _, tt := range tests {
+		if got := readURLs(t, tt.name, tt.opts); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s (%d items) = %v, want %v", tt.name, tt.opts.feedItems, got, tt.want)
+		}
+	}
+}
+
+func TestFeedRelativeToURL(t *testing.T) {
+	b, err := os.ReadFile("testdata/feed.rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(b) }))
+	defer srv.Close()
+
+	// a feed fetched from a URL resolves links against it, not its site
+	got := readURLs(t, srv.URL+"/news/feed.rss", inputOptions{})
+	if len(got) != 3 || got[0] != srv.URL+"/news/c" {
+		t.Errorf("got %v, want %s/news/c first", got, srv.URL)
+	}
+}
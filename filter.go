@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"regexp"
+)
+
+// urlFilter selects the input URLs to capture with -include and -exclude: a
+// URL must match one of the include patterns, if there are any, and none of
+// the exclude ones.
+type urlFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newURLFilter(include, exclude []string) (*urlFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	f := &urlFilter{}
+	for _, list := range []struct {
+		patterns []string
+		dst      *[]*regexp.Regexp
+	}{{include, &f.include}, {exclude, &f.exclude}} {
+		for _, p := range list.patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, err
+			}
+			*list.dst = append(*list.dst, re)
+		}
+	}
+	return f, nil
+}
+
+func (f *urlFilter) admits(u string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredSource leaves out the jobs its filter doesn't admit. Invalid lines
+// are passed on, to be reported.
+type filteredSource struct {
+	jobs   jobSource
+	filter *urlFilter
+	// dropped counts the URLs left out.
+	dropped int
+}
+
+func (s *filteredSource) next() (job, error) {
+	for {
+		j, err := s.jobs.next()
+		if err != nil || s.filter.admits(j.url) {
+			return j, err
+		}
+		s.dropped++
+	}
+}
+
+func (s *filteredSource) Close() error {
+	if closer, ok := s.jobs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
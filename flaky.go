@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// flakyCellSize is the side in pixels of the grid cells a screenshot is
+// fingerprinted in; changes are located, and masks suggested, per cell.
+const flakyCellSize = 32
+
+// flakiness remembers a fingerprint of every shot across runs, so shots that
+// change every single time (ads, clocks, rotating banners) can be told apart
+// from real regressions. Only runs with the same capture options are compared.
+type flakiness struct {
+	mu      sync.Mutex
+	path    string
+	runs    int
+	Entries map[string]*flakyEntry `json:"entries"`
+}
+
+type flakyEntry struct {
+	// Signature identifies the capture options; a change resets the entry.
+	Signature string   `json:"signature"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	Cells     []uint64 `json:"cells"`
+	// ChangedRuns counts the consecutive runs the shot changed in, and
+	// CellChanges how many of those runs each cell changed in.
+	ChangedRuns int   `json:"changedRuns"`
+	CellChanges []int `json:"cellChanges"`
+}
+
+// loadFlakiness reads the history at path; a missing file starts empty.
+// A shot is flaky once it changed in runs consecutive runs.
+func loadFlakiness(path string, runs int) (*flakiness, error) {
+	if runs < 1 {
+		return nil, fmt.Errorf("-flakyRuns must be at least 1")
+	}
+
+	f := &flakiness{path: path, runs: runs, Entries: map[string]*flakyEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Entries == nil {
+		f.Entries = map[string]*flakyEntry{}
+	}
+	return f, nil
+}
+
+// flakySignature hashes the options that decide what a shot looks like. The
+// proxy is left out since rotation changes it from run to run.
+func flakySignature(u string, opts captureOptions) string {
+	opts.proxy = ""
+	sum := sha256.Sum256([]byte(coalesceKey(u, opts)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fingerprint hashes every grid cell of img. Channels are quantized so that
+// compression noise doesn't register as a change.
+func fingerprint(img image.Image) (w, h int, cells []uint64) {
+	b := img.Bounds()
+	w, h = b.Dx(), b.Dy()
+	cols, rows := (w+flakyCellSize-1)/flakyCellSize, (h+flakyCellSize-1)/flakyCellSize
+
+	hashes := make([]hash.Hash64, cols*rows)
+	for i := range hashes {
+		hashes[i] = fnv.New64a()
+	}
+
+	px := make([]byte, 3)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+			px[0], px[1], px[2] = c.R>>4, c.G>>4, c.B>>4
+			_, _ = hashes[(y/flakyCellSize)*cols+x/flakyCellSize].Write(px)
+		}
+	}
+
+	cells = make([]uint64, len(hashes))
+	for i, hf := range hashes {
+		cells[i] = hf.Sum64()
+	}
+	return w, h, cells
+}
+
+// observe compares img with the previous run's shot under key and records on
+// res whether the shot is flaky, with the regions that changed every time.
+func (f *flakiness) observe(key, signature string, img image.Image, res *captureResult) {
+	if f == nil {
+		return
+	}
+
+	w, h, cells := fingerprint(img)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev := f.Entries[key]
+	next := &flakyEntry{Signature: signature, Width: w, Height: h, Cells: cells, CellChanges: make([]int, len(cells))}
+	f.Entries[key] = next
+	if prev == nil || prev.Signature != signature {
+		return
+	}
+
+	if prev.Width != w || prev.Height != h {
+		// a different size changes everything; start counting cells anew
+		next.ChangedRuns = prev.ChangedRuns + 1
+	} else {
+		changed := false
+		for i := range cells {
+			if cells[i] != prev.Cells[i] {
+				changed = true
+				next.CellChanges[i] = 1
+			}
+		}
+		if !changed {
+			return
+		}
+		next.ChangedRuns = prev.ChangedRuns + 1
+		if len(prev.CellChanges) == len(cells) {
+			for i := range cells {
+				next.CellChanges[i] += prev.CellChanges[i]
+			}
+		}
+	}
+
+	if next.ChangedRuns >= f.runs {
+		res.Flaky = true
+		res.FlakyRegions = next.regions()
+	}
+}
+
+// regions returns the cells that changed in every run of the streak as
+// "x,y,width,height" rectangles, merging neighbouring cells.
+func (e *flakyEntry) regions() []string {
+	cols := (e.Width + flakyCellSize - 1) / flakyCellSize
+	if cols == 0 {
+		return nil
+	}
+	always := func(i int) bool { return e.CellChanges[i] >= e.ChangedRuns }
+
+	type span struct{ x0, x1, y0, y1 int }
+	var open, done []span
+	rows := len(e.CellChanges) / cols
+	for row := 0; row <= rows; row++ {
+		var spans []span
+		for col := 0; row < rows && col < cols; col++ {
+			if !always(row*cols + col) {
+				continue
+			}
+			if n := len(spans); n > 0 && spans[n-1].x1 == col {
+				spans[n-1].x1++
+			} else {
+				spans = append(spans, span{x0: col, x1: col + 1, y0: row, y1: row + 1})
+			}
+		}
+
+		// extend the rectangles of the previous row that have the same span
+		for _, o := range open {
+			extended := false
+			for i, s := range spans {
+				if s.x0 == o.x0 && s.x1 == o.x1 {
+					spans[i].y0 = o.y0
+					extended = true
+				}
+			}
+			if !extended {
+				done = append(done, o)
+			}
+		}
+		open = spans
+	}
+
+	var regions []string
+	for _, s := range done {
+		x, y := s.x0*flakyCellSize, s.y0*flakyCellSize
+		regions = append(regions, fmt.Sprintf("%d,%d,%d,%d", x, y,
+			min(s.x1*flakyCellSize, e.Width)-x, min(s.y1*flakyCellSize, e.Height)-y))
+	}
+	return regions
+}
+
+// save writes the history for the next run.
+func (f *flakiness) save() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(f.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.path+".tmp", f.path)
+}
+
+func logFlaky(runOptions *runOptions, res *captureResult, logger *log.Logger) {
+	if !res.Flaky {
+		return
+	}
+	masks := "none"
+	if len(res.FlakyRegions) > 0 {
+		masks = strings.Join(res.FlakyRegions, " ")
+	}
+	logger.Printf("%s looks flaky: it changed in each of the last %d runs; suggested masks: %s", runOptions.value(res.FileName), runOptions.flakiness.runs, masks)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// followInterval is how often -follow checks the input for new lines.
+const followInterval = time.Second
+
+// followReader reads a local file like tail -f: at the end of the file it
+// waits for more to be appended instead of returning io.EOF, starts over
+// when the file is truncated and reopens it when it is replaced, as log
+// rotation does. It returns io.EOF once ctx is done.
+type followReader struct {
+	ctx  context.Context
+	name string
+	f    *os.File
+	read int64
+}
+
+func openFollow(ctx context.Context, name string) (*followReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &followReader{ctx: ctx, name: name, f: f}, nil
+}
+
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.f.Read(p)
+		fr.read += int64(n)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		select {
+		case <-fr.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followInterval):
+		}
+		fr.check()
+	}
+}
+
+// check reopens the input when it was replaced and rewinds it when it was
+// truncated. Errors are left for the next check, as a rotating file may be
+// briefly missing.
+func (fr *followReader) check() {
+	current, err := fr.f.Stat()
+	if err != nil {
+		return
+	}
+	latest, err := os.Stat(fr.name)
+	if err != nil {
+		return
+	}
+
+	if !os.SameFile(current, latest) {
+		// finish what was appended to the old file before switching
+		if current.Size() > fr.read {
+			return
+		}
+		f, err := os.Open(fr.name)
+		if err != nil {
+			return
+		}
+		fr.f.Close()
+		fr.f, fr.read = f, 0
+		return
+	}
+	if latest.Size() < fr.read {
+		if _, err = fr.f.Seek(0, io.SeekStart); err == nil {
+			fr.read = 0
+		}
+	}
+}
+
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
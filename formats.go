@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/chai2010/webp"
+)
+
+// formatRank orders the supported formats by fidelity. When several formats
+// are requested the best one is rendered and the rest are transcoded from it.
+var formatRank = map[string]int{
+	"jpeg": 1,
+	"webp": 2,
+	"png":  3,
+}
+
+// parseImageFormats turns "jpeg,png,webp" into the format rendered by the
+// server and the formats produced locally from it.
+func parseImageFormats(value string) (imageFormat, error) {
+	var formats []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "jpg" {
+			f = "jpeg"
+		}
+		if _, ok := formatRank[f]; !ok {
+			return imageFormat{}, fmt.Errorf("unsupported image format %q", f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+
+	best := 0
+	for i, f := range formats {
+		if formatRank[f] > formatRank[formats[best]] {
+			best = i
+		}
+	}
+
+	imf := imageFormat{format: formats[best]}
+	for i, f := range formats {
+		if i != best {
+			imf.transcodeTo = append(imf.transcodeTo, f)
+		}
+	}
+	return imf, nil
+}
+
+// extraFormats lists the requested formats other than the one a shot is
+// saved as.
+func (imf imageFormat) extraFormats(rendered string) []string {
+	var extra []string
+	for _, f := range append([]string{imf.format}, imf.transcodeTo...) {
+		if f != rendered {
+			extra = append(extra, f)
+		}
+	}
+	return extra
+}
+
+// transcode decodes the image of out and stores it once per extra format
+// under the base name of fileName, returning the stored locations.
+func transcode(runOptions *runOptions, out *rendered, fileName, u string, formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		return nil, nil
+	}
+
+	defer runOptions.memory.reserve(out.decodedSize() + uploadBuffers(runOptions.storage))()
+
+	img, err := out.decode()
+	if err != nil {
+		return nil, fmt.Errorf("can't decode %s for transcoding: %w", fileName, err)
+	}
+
+	base := strings.TrimSuffix(fileName, "."+extension(fileName))
+	var stored []string
+	for _, f := range formats {
+		location, _, err := storeEncoded(runOptions, img, base+"."+f, f, u, io.Discard)
+		if err != nil {
+			return stored, err
+		}
+		stored = append(stored, location)
+	}
+	return stored, nil
+}
+
+// convert stores the image of out, rendered by a fallback in another format
+// than the one asked for, re-encoded as res.FileName, so the shot is saved
+// under its name like any other.
+func convert(runOptions *runOptions, out *rendered, u string, res *captureResult) error {
+	defer runOptions.memory.reserve(out.decodedSize() + uploadBuffers(runOptions.storage))()
+
+	img, err := out.decode()
+	if err != nil {
+		return fmt.Errorf("can't decode %s for transcoding: %w", out.fileName, err)
+	}
+
+	h, n := sha256.New(), &byteCounter{}
+	f := extension(res.FileName)
+	if res.StoragePath, res.Destinations, err = storeEncoded(runOptions, img, res.FileName, f, u, io.MultiWriter(h, n)); err != nil {
+		return err
+	}
+	res.Bytes, res.SHA256 = n.n, hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// storeEncoded encodes img as format straight into the upload of name
+// rather than into a buffer, copying what it encodes to sum.
+func storeEncoded(runOptions *runOptions, img image.Image, name, format, u string, sum io.Writer) (string, []destinationResult, error) {
+	pr, pw := io.Pipe()
+	encoded := make(chan error, 1)
+	go func() {
+		err := encodeImage(io.MultiWriter(pw, sum), img, format)
+		pw.CloseWithError(err)
+		encoded <- err
+	}()
+
+	metadata := map[string]string{"content-type": contentTypes[format], "source-url": u}
+	location, destinations, err := store(runOptions, name, pr, metadata)
+	pr.Close()
+	if encErr := <-encoded; encErr != nil && !errors.Is(encErr, io.ErrClosedPipe) {
+		return "", nil, fmt.Errorf("can't transcode to %s: %w", format, encErr)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("can't store %s: %w", name, err)
+	}
+	return location, destinations, nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+	case "webp":
+		return webp.Encode(w, img, &webp.Options{Quality: 90})
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
+
+func extension(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,196 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative screenshoter.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eubelov/screenshoter/screenshoter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Transports to the screenshot server, set with protocol in config.yaml.
+const (
+	protocolHTTP = "http"
+	protocolGRPC = "grpc"
+)
+
+// dialGRPC connects to a server of config.yaml speaking screenshoter.proto.
+// The host scheme picks the transport security: https means TLS.
+func dialGRPC(conf serverConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	target := conf.Host
+	if u, err := url.Parse(conf.Host); err == nil && u.Host != "" {
+		if u.Scheme == "https" {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+		target = u.Hostname()
+	}
+	return grpc.NewClient(fmt.Sprintf("%s:%d", target, conf.Port), grpc.WithTransportCredentials(creds))
+}
+
+// pingGRPC asks the standard gRPC health service whether the server is
+// serving.
+func pingGRPC(conf serverConfig) error {
+	conn, err := dialGRPC(conf)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("ping returned %s", resp.Status)
+	}
+	return nil
+}
+
+// probeGRPCCapabilities is probeCapabilities for gRPC servers.
+func probeGRPCCapabilities(conf serverConfig, logger *log.Logger) *capabilities {
+	conn, err := dialGRPC(conf)
+	if err != nil {
+		logger.Printf("capabilities probe failed, assuming a legacy server: %v", err)
+		return nil
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := NewScreenshotsClient(conn).Capabilities(ctx, &CapabilitiesRequest{})
+	if err != nil {
+		logger.Printf("server %s does not advertise capabilities, assuming a legacy server", conf.name())
+		return nil
+	}
+
+	logger.Printf("server %s version %q supports %s", conf.name(), resp.Version, strings.Join(resp.Parameters, ", "))
+	return &capabilities{Version: resp.Version, Parameters: conf.clientParams(resp.Parameters)}
+}
+
+// renderGRPC is render over the Render stream of srv. Its status codes are
+// mapped to the HTTP ones so retries and failover treat both alike.
+func renderGRPC(ctx context.Context, runOptions *runOptions, srv *serverState, params url.Values, captureID string, opts captureOptions) (*rendered, error) {
+	req := &RenderRequest{Params: flatParams(screenshoter.RenameParams(params, srv.conf.Params))}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, runIDHeader, runOptions.runID, captureIDHeader, captureID)
+	if timeout := runOptions.client.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	renderStart := time.Now()
+	out := &rendered{fileName: params.Get("FileName")}
+	if runOptions.servers.multiple() {
+		out.server = srv.conf.name()
+	}
+	defer func() { runOptions.usage.add(out.bytes, time.Since(renderStart)) }()
+
+	stream, err := NewScreenshotsClient(srv.conn).Render(ctx, req)
+	var first *RenderResponse
+	if err == nil {
+		first, err = stream.Recv()
+	}
+	if err == io.EOF {
+		err = status.Error(codes.Internal, "empty stream")
+	}
+	if err != nil {
+		out.statusCode = grpcStatusCode(err)
+		if out.statusCode == 0 {
+			err = fmt.Errorf("request to server failed: %s", status.Convert(err).Message())
+			runOptions.servers.report(srv, 0, err)
+			return nil, err
+		}
+		err = fmt.Errorf("server returned %s", status.Convert(err).Code())
+		runOptions.servers.report(srv, out.statusCode, err)
+		return out, err
+	}
+	out.statusCode = 200
+	runOptions.servers.report(srv, out.statusCode, nil)
+	if header, herr := stream.Header(); herr == nil {
+		out.info = srv.conf.ResultHeaders.parse(func(name string) string { return strings.Join(header.Get(name), ", ") })
+	}
+
+	out.contentType = first.ContentType
+	if !strings.HasPrefix(out.contentType, "image/") {
+		out.contentType = contentTypes[opts.format]
+	}
+	if limit := runOptions.maxImageSize; limit > 0 && first.Size > limit {
+		return out, fmt.Errorf("%w: server announced %d bytes, limit is %d", errOversized, first.Size, limit)
+	}
+	expected := first.Size
+	if expected == 0 {
+		expected = -1
+	}
+	err = spool(ctx, runOptions, out, &renderStream{stream: stream, chunk: first.Data}, expected)
+	if out.diagnostics != nil {
+		if header, herr := stream.Header(); herr == nil {
+			out.diagnostics.Headers = flatHeaders(header)
+		}
+		out.diagnostics.Headers = withMetadata(out.diagnostics.Headers, map[string]string{"content-type": first.ContentType})
+	}
+	return out, err
+}
+
+// grpcStatusCode translates a gRPC error into the HTTP status a server would
+// have answered with; 0 means the server couldn't be reached at all.
+func grpcStatusCode(err error) int {
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return 0
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return 400
+	case codes.Unauthenticated:
+		return 401
+	case codes.PermissionDenied:
+		return 403
+	case codes.NotFound:
+		return 404
+	case codes.ResourceExhausted:
+		return 429
+	case codes.Unimplemented:
+		return 501
+	case codes.DeadlineExceeded:
+		return 504
+	}
+	return 500
+}
+
+// renderStream reads the image chunks of a Render stream.
+type renderStream struct {
+	stream grpc.ServerStreamingClient[RenderResponse]
+	chunk  []byte
+}
+
+func (r *renderStream) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		msg, err := r.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("server returned %s", status.Convert(err).Code())
+		}
+		r.chunk = msg.Data
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
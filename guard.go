@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// failureGuard watches the failure rate of the most recent captures and backs
+// off while the renderer is having an incident: first by halving concurrency,
+// then by pausing dispatch, and finally by aborting the run. It also enforces
+// the run-wide budget of fallback retries.
+type failureGuard struct {
+	mu      sync.Mutex
+	window  []bool
+	next    int
+	filled  int
+	retries int64
+
+	throttleAt  float64
+	pauseAt     float64
+	abortAt     float64
+	pauseFor    time.Duration
+	concurrency int64
+	budget      int64
+	sem         *semaphore.Weighted
+
+	// held is the number of semaphore slots taken away while throttled; it is
+	// only touched by the dispatching goroutine.
+	held int64
+}
+
+func newFailureGuard(sem *semaphore.Weighted, window, concurrency int, throttleAt, pauseAt, abortAt float64, pauseFor time.Duration, budget int) (*failureGuard, error) {
+	if window < 1 {
+		return nil, fmt.Errorf("failure window must be at least 1")
+	}
+	for _, rate := range []float64{throttleAt, pauseAt, abortAt} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("failure rate %v is not between 0 and 1", rate)
+		}
+	}
+
+	return &failureGuard{
+		sem:         sem,
+		window:      make([]bool, window),
+		throttleAt:  throttleAt,
+		pauseAt:     pauseAt,
+		abortAt:     abortAt,
+		pauseFor:    pauseFor,
+		concurrency: int64(concurrency),
+		budget:      int64(budget),
+	}, nil
+}
+
+func (g *failureGuard) record(res *captureResult) error {
+	// input errors say nothing about the renderer
+	if res.CaptureID == "" {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.window[g.next] = res.Status == statusFailed
+	g.next = (g.next + 1) % len(g.window)
+	if g.filled < len(g.window) {
+		g.filled++
+	}
+	return nil
+}
+
+// rate returns the failure rate over the window, or false until the window
+// has filled up.
+func (g *failureGuard) rate() (float64, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.filled < len(g.window) {
+		return 0, false
+	}
+
+	failed := 0
+	for _, f := range g.window {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(g.window)), true
+}
+
+func (g *failureGuard) reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.next, g.filled = 0, 0
+}
+
+// admit is called before each capture is dispatched. It adjusts concurrency,
+// pauses, or returns an error when the run must be aborted.
+func (g *failureGuard) admit(logger *log.Logger) error {
+	rate, ok := g.rate()
+	if !ok {
+		return nil
+	}
+	pct := rate * 100
+
+	if g.abortAt > 0 && rate >= g.abortAt {
+		return fmt.Errorf("failure rate %.0f%% over the last %d captures reached -abortFailureRate", pct, len(g.window))
+	}
+
+	if g.pauseAt > 0 && rate >= g.pauseAt {
+		logger.Printf("failure rate %.0f%% over the last %d captures: pausing for %s", pct, len(g.window), g.pauseFor)
+		time.Sleep(g.pauseFor)
+		// start measuring afresh so the failures that caused the pause don't
+		// trigger it again straight away, and resume throttled
+		g.reset()
+		logger.Printf("resuming after pause")
+	}
+
+	switch {
+	case g.throttleAt > 0 && rate >= g.throttleAt && g.held == 0:
+		reduced := g.concurrency / 2
+		if reduced < 1 {
+			reduced = 1
+		}
+		if g.held = g.concurrency - reduced; g.held == 0 {
+			return nil
+		}
+		logger.Printf("failure rate %.0f%% over the last %d captures: reducing concurrency to %d", pct, len(g.window), reduced)
+		if err := g.sem.Acquire(ctx, g.held); err != nil {
+			g.held = 0
+			logger.Printf("failed to acquire semaphore: %v", err)
+		}
+	case g.held > 0 && rate < g.throttleAt:
+		logger.Printf("failure rate %.0f%% over the last %d captures: restoring concurrency to %d", pct, len(g.window), g.concurrency)
+		g.stop()
+	}
+	return nil
+}
+
+// stop gives back any slots held while throttled.
+func (g *failureGuard) stop() {
+	if g.held > 0 {
+		g.sem.Release(g.held)
+		g.held = 0
+	}
+}
+
+// spendRetry takes one retry from the run's budget, reporting false once it
+// is used up.
+func (g *failureGuard) spendRetry() bool {
+	if g.budget <= 0 {
+		return true
+	}
+	return atomic.AddInt64(&g.retries, 1) <= g.budget
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// newRenderClient returns the client shared by every render request. All of
+// them go to the same server, so idle connections are kept per host rather
+// than the http.Transport default of two.
+func newRenderClient(timeout time.Duration, maxIdle int, idleTimeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pages are adjusted before capture by CSS and JavaScript that the renderer
+// injects through the Style, Script (run after load) and InitScript (run
+// before any page script) action parameters. Each option that needs it
+// contributes a snippet; snippets are concatenated in a fixed order.
+
+//go:embed banners/hide.css
+var bannerCSS string
+
+//go:embed banners/accept.js
+var bannerJS string
+
+//go:embed animations/disable.css
+var animationsCSS string
+
+//go:embed animations/pause.js
+var animationsJS string
+
+func joinSnippets(snippets ...string) string {
+	var parts []string
+	for _, s := range snippets {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// freezeTimeJS pins Date to a fixed instant so clocks and countdowns render
+// the same on every run.
+func freezeTimeJS(at time.Time) string {
+	return fmt.Sprintf(`(function () {
+    var frozen = %d;
+    var RealDate = Date;
+    function FrozenDate() {
+        var args = Array.prototype.slice.call(arguments);
+        // Date() called as a function returns the current time as a string
+        if (!new.target) { return new RealDate(frozen).toString(); }
+        return Reflect.construct(RealDate, args.length === 0 ? [frozen] : args, new.target);
+    }
+    FrozenDate.prototype = RealDate.prototype;
+    FrozenDate.parse = RealDate.parse;
+    FrozenDate.UTC = RealDate.UTC;
+    FrozenDate.now = function () { return frozen; };
+    window.Date = FrozenDate;
+    if (window.performance) { var start = performance.now(); performance.now = function () { return start; }; }
+})();`, at.UnixMilli())
+}
+
+// seedRandomJS replaces Math.random with a seeded mulberry32 generator so
+// randomized content is the same on every run.
+func seedRandomJS(seed int64) string {
+	return fmt.Sprintf(`(function () {
+    var s = %d >>> 0;
+    Math.random = function () {
+        s = (s + 0x6D2B79F5) | 0;
+        var t = Math.imul(s ^ (s >>> 15), 1 | s);
+        t = (t + Math.imul(t ^ (t >>> 7), 61 | t)) ^ t;
+        return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+    };
+})();`, uint32(seed))
+}
+
+// injections returns the snippets to send for the current options.
+func (runOptions *runOptions) injections() (style, script, initScript string) {
+	if runOptions.dismissBanners {
+		style = joinSnippets(style, bannerCSS)
+		script = joinSnippets(script, bannerJS)
+	}
+	if runOptions.disableAnims {
+		style = joinSnippets(style, animationsCSS)
+		script = joinSnippets(script, animationsJS)
+	}
+	if !runOptions.freezeTime.IsZero() {
+		initScript = joinSnippets(initScript, freezeTimeJS(runOptions.freezeTime))
+	}
+	if runOptions.seedRandom != 0 {
+		initScript = joinSnippets(initScript, seedRandomJS(runOptions.seedRandom))
+	}
+	return style, script, initScript
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// job is a single URL taken from the input together with where it came from.
+type job struct {
+	url     string
+	line    int
+	options jobOptions
+}
+
+// jobOptions are per-URL overrides taken from tabular and JSON Lines inputs;
+// they win over flags and domain overrides. fileName replaces the base of the
+// file names, format the rendered format and every one of -imageFormat.
+// style and script are injected after those of the flags.
+type jobOptions struct {
+	width    int
+	height   int
+	delay    *int
+	fileName string
+	format   string
+
+	waitForFunction string
+	waitForTimeout  time.Duration
+	disableJS       *bool
+	headers         map[string]string
+	cookies         map[string]string
+	style           string
+	script          string
+	// tags are copied to the results of the URL.
+	tags []string
+	// params are extra action parameters forwarded to the server as they
+	// are.
+	params map[string]string
+}
+
+func (j jobOptions) apply(o captureOptions) captureOptions {
+	if j.delay != nil {
+		o.delay = *j.delay
+	}
+	if j.format != "" {
+		o.format = j.format
+	}
+	if j.waitForFunction != "" {
+		o.waitForFunction = j.waitForFunction
+	}
+	if j.waitForTimeout > 0 {
+		o.waitForTimeout = j.waitForTimeout
+	}
+	if j.disableJS != nil {
+		o.disableJS = *j.disableJS
+	}
+	if len(j.headers) > 0 {
+		o.headers = j.headers
+	}
+	if len(j.cookies) > 0 {
+		o.cookies = j.cookies
+	}
+	if len(j.params) > 0 {
+		o.params = j.params
+	}
+	o.style = joinSnippets(o.style, j.style)
+	o.script = joinSnippets(o.script, j.script)
+	if j.width > 0 {
+		o.width = j.width
+	}
+	if j.height > 0 {
+		o.height = j.height
+	}
+	return o
+}
+
+// jobSource yields the jobs of a run; next returns io.EOF once it is
+// exhausted. Sources holding resources also implement io.Closer.
+type jobSource interface {
+	next() (job, error)
+}
+
+// streamSource is a lineReader over an input it closes when the run is done.
+type streamSource struct {
+	*lineReader
+	io.Closer
+}
+
+// jsonlSource is a jsonlReader over an input it closes when the run is done.
+type jsonlSource struct {
+	*jsonlReader
+	io.Closer
+}
+
+// tableSource is a tableReader over an input it closes when the run is done.
+type tableSource struct {
+	*tableReader
+	io.Closer
+}
+
+// lineReader yields jobs from a plain text URL list. Unlike bufio.Scanner it
+// has no token size limit, and it tolerates Windows line endings, a UTF-8 BOM,
+// blank lines and #-comments.
+type lineReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReader(r)}
+}
+
+// next returns the next job or io.EOF once the input is exhausted. Lines that
+// fail validation are returned as an error carrying their line number.
+func (lr *lineReader) next() (job, error) {
+	for {
+		text, err := lr.r.ReadString('\n')
+		if err != nil && (err != io.EOF || text == "") {
+			if err != io.EOF {
+				err = fmt.Errorf("line %d: %w", lr.line+1, err)
+			}
+			return job{}, err
+		}
+
+		lr.line++
+		if lr.line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if _, perr := url.Parse(text); perr != nil {
+			return job{url: text, line: lr.line}, fmt.Errorf("line %d: %w", lr.line, perr)
+		}
+
+		return job{url: text, line: lr.line}, nil
+	}
+}
+
+// rowReader yields the rows of a table input, like csv.Reader.
+type rowReader interface {
+	Read() ([]string, error)
+}
+
+// sliceRows is a rowReader over rows already in memory.
+type sliceRows [][]string
+
+func (r *sliceRows) Read() ([]string, error) {
+	if len(*r) == 0 {
+		return nil, io.EOF
+	}
+	row := (*r)[0]
+	*r = (*r)[1:]
+	return row, nil
+}
+
+// tableReader yields jobs from rows whose first row names the columns. A
+// "url" column is required; "width", "height", "delay", "filename" and
+// "format" are optional per-URL overrides. Columns named "param.<Name>" are
+// extra action parameters, Name keeping its case.
+type tableReader struct {
+	rows    rowReader
+	columns map[string]int
+	params  map[string]int
+	row     int
+}
+
+func newTableReader(rows rowReader) (*tableReader, error) {
+	header, err := rows.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("input has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns, params := map[string]int{}, map[string]int{}
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if len(name) > len(paramPrefix) && strings.EqualFold(name[:len(paramPrefix)], paramPrefix) {
+			params[name[len(paramPrefix):]] = i
+			continue
+		}
+		columns[strings.ToLower(name)] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, fmt.Errorf("input has no url column")
+	}
+	return &tableReader{rows: rows, columns: columns, params: params, row: 1}, nil
+}
+
+func (tr *tableReader) cell(row []string, name string) string {
+	i, ok := tr.columns[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func (tr *tableReader) next() (job, error) {
+	for {
+		row, err := tr.rows.Read()
+		if err == io.EOF {
+			return job{}, io.EOF
+		}
+		tr.row++
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			// like an invalid cell, a malformed row is reported and the
+			// rest still read, which needs it to carry a URL
+			return job{url: fmt.Sprintf("row %d", tr.row), line: tr.row}, fmt.Errorf("row %d: %w", tr.row, err)
+		}
+		if err != nil {
+			return job{}, fmt.Errorf("row %d: %w", tr.row, err)
+		}
+
+		j := job{url: tr.cell(row, "url"), line: tr.row}
+		if j.url == "" || strings.HasPrefix(j.url, "#") {
+			continue
+		}
+
+		if _, err := url.Parse(j.url); err != nil {
+			return j, fmt.Errorf("row %d: %w", j.line, err)
+		}
+
+		for _, col := range []struct {
+			name string
+			dst  *int
+		}{{"width", &j.options.width}, {"height", &j.options.height}} {
+			if v := tr.cell(row, col.name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					return j, fmt.Errorf("row %d: invalid %s %q", j.line, col.name, v)
+				}
+				*col.dst = n
+			}
+		}
+		if v := tr.cell(row, "delay"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return j, fmt.Errorf("row %d: invalid delay %q", j.line, v)
+			}
+			j.options.delay = &n
+		}
+		if err := j.options.setFormat(tr.cell(row, "format")); err != nil {
+			return j, fmt.Errorf("row %d: %w", j.line, err)
+		}
+		if err := j.options.setFileName(tr.cell(row, "filename")); err != nil {
+			return j, fmt.Errorf("row %d: %w", j.line, err)
+		}
+		for name, i := range tr.params {
+			// empty cells leave the parameter out, like the other columns
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				if j.options.params == nil {
+					j.options.params = map[string]string{}
+				}
+				j.options.params[name] = strings.TrimSpace(row[i])
+			}
+		}
+
+		return j, nil
+	}
+}
+
+// paramPrefix starts the names of the table columns holding extra action
+// parameters.
+const paramPrefix = "param."
+
+// rowFormat normalizes a format given by a row, accepting jpg for jpeg.
+func rowFormat(v string) string {
+	v = strings.ToLower(v)
+	if v == "jpg" {
+		return "jpeg"
+	}
+	return v
+}
+
+func (j *jobOptions) setFormat(v string) error {
+	if v = rowFormat(v); v == "" {
+		return nil
+	}
+	if _, ok := formatRank[v]; !ok {
+		return fmt.Errorf("invalid format %q (use jpeg, png or webp)", v)
+	}
+	j.format = v
+	return nil
+}
+
+// setFileName sets the base of the file names. A name with the extension of
+// a format also picks the format, unless one was given.
+func (j *jobOptions) setFileName(v string) error {
+	if v == "" {
+		return nil
+	}
+	if ext := rowFormat(extension(v)); formatRank[ext] > 0 {
+		v = strings.TrimSuffix(v, "."+extension(v))
+		if j.format == "" {
+			j.format = ext
+		}
+	}
+	name, err := relativePath(v)
+	if err != nil {
+		return fmt.Errorf("invalid filename %q", v)
+	}
+	j.fileName = name
+	return nil
+}
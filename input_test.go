@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSVMalformedRow(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "urls.csv")
+	list := "url,width\n" +
+		"https://example.com/a,800\n" +
+		"https://example.com/b\"c,800\n" +
+		"https://example.com/d,wide\n" +
+		"https://example.com/e,1024\n"
+	if err := os.WriteFile(name, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := openJobs(context.Background(), name, inputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.(io.Closer).Close()
+
+	// the bare quote and the invalid width fail their rows only
+	want := []struct {
+		url string
+		err string
+	}{
+		{"https://example.com/a", ""},
+		{"row 3", "row 3: parse error"},
+		{"https://example.com/d", `row 4: invalid width "wide"`},
+		{"https://example.com/e", ""},
+	}
+	for i, w := range want {
+		j, err := src.next()
+		if err == io.EOF {
+			t.Fatalf("row %d: unexpected end, want %s", i+2, w.url)
+		}
+		if j.url != w.url || j.line != i+2 {
+			t.Errorf("got %s row %d, want %s row %d", j.url, j.line, w.url, i+2)
+		}
+		if (err == nil) != (w.err == "") || (err != nil && !strings.Contains(err.Error(), w.err)) {
+			t.Errorf("row %d: error %v, want %q", i+2, err, w.err)
+		}
+	}
+	if _, err := src.next(); err != io.EOF {
+		t.Errorf("got %v after the last row, want the end", err)
+	}
+}
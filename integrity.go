@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// snippetSize is how much of the body of an incomplete response is kept.
+const snippetSize = 256
+
+// responseDiagnostics describes a render the server answered with success
+// but whose body was empty or cut short, for reporting it to the server's
+// maintainers.
+type responseDiagnostics struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	// Snippet is the start of the body, as text when it is text (an error
+	// page, say) and hex otherwise.
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// headWriter keeps the first snippetSize bytes written to it.
+type headWriter struct {
+	head []byte
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := snippetSize - len(w.head); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		w.head = append(w.head, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// isText reports whether the start of a body is text, which no image is.
+func isText(head []byte) bool {
+	return len(head) > 0 && utf8.Valid(head) && strings.IndexFunc(string(head), func(r rune) bool { return !unicode.IsPrint(r) && !unicode.IsSpace(r) }) < 0
+}
+
+func snippet(head []byte) string {
+	if isText(head) {
+		return string(head)
+	}
+	if len(head) > 64 {
+		head = head[:64]
+	}
+	return "hex:" + hex.EncodeToString(head)
+}
+
+// flatHeaders joins the values of the headers of a response.
+func flatHeaders(header map[string][]string) map[string]string {
+	flat := make(map[string]string, len(header))
+	for k, v := range header {
+		flat[k] = strings.Join(v, ", ")
+	}
+	return flat
+}
+
+// tailSize is how much of the end of a body the end marker is looked for in.
+const tailSize = 64
+
+// tailWriter keeps the last tailSize bytes written to it.
+type tailWriter struct {
+	tail []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	if len(p) >= tailSize {
+		w.tail = append(w.tail[:0], p[len(p)-tailSize:]...)
+		return len(p), nil
+	}
+	w.tail = append(w.tail, p...)
+	if extra := len(w.tail) - tailSize; extra > 0 {
+		w.tail = append(w.tail[:0], w.tail[extra:]...)
+	}
+	return len(p), nil
+}
+
+// checkComplete looks for the end marker of the PNG, JPEG or WebP image of
+// size bytes that starts with head and ends with end, to catch bodies cut
+// short without a Content-Length to compare against. Other formats pass.
+func checkComplete(head, end []byte, size int64) error {
+	if len(head) < 12 {
+		return fmt.Errorf("%w: only %d bytes", errIncomplete, size)
+	}
+	start := head[:12]
+
+	switch {
+	case bytes.HasPrefix(start, []byte("\x89PNG\r\n\x1a\n")):
+		if !bytes.HasSuffix(end, []byte("IEND\xaeB`\x82")) {
+			return fmt.Errorf("%w: PNG has no IEND chunk", errIncomplete)
+		}
+	case bytes.HasPrefix(start, []byte{0xff, 0xd8}):
+		// encoders may pad after the end of image marker
+		if !bytes.HasSuffix(bytes.TrimRight(end, "\x00"), []byte{0xff, 0xd9}) {
+			return fmt.Errorf("%w: JPEG has no end of image marker", errIncomplete)
+		}
+	case bytes.HasPrefix(start, []byte("RIFF")) && bytes.Equal(start[8:12], []byte("WEBP")):
+		if want := int64(binary.LittleEndian.Uint32(start[4:8])) + 8; want > size {
+			return fmt.Errorf("%w: WebP has %d of %d bytes", errIncomplete, size, want)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// jsonJob is a line of a JSON Lines input: a URL and the options to capture
+// it with, named like the flags. Unknown fields are rejected so that typos
+// don't silently fall back to the defaults.
+type jsonJob struct {
+	URL      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Delay    *int   `json:"delay"`
+	FileName string `json:"filename"`
+	Format   string `json:"format"`
+	// WaitFor is a JavaScript expression the page is rendered once it is
+	// truthy, like -waitForFunction.
+	WaitFor        string            `json:"waitFor"`
+	WaitForTimeout string            `json:"waitForTimeout"`
+	DisableJS      *bool             `json:"disableJS"`
+	Headers        map[string]string `json:"headers"`
+	Cookies        map[string]string `json:"cookies"`
+	Style          string            `json:"style"`
+	Script         string            `json:"script"`
+	Tags           []string          `json:"tags"`
+	// Params are extra action parameters forwarded to the server as they
+	// are; numbers and booleans are sent in their JSON form.
+	Params map[string]interface{} `json:"params"`
+}
+
+// jsonlReader yields jobs from a JSON Lines input (named .jsonl or .ndjson),
+// one object per line. Blank lines and #-comments are skipped.
+type jsonlReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	return &jsonlReader{r: bufio.NewReader(r)}
+}
+
+func (jr *jsonlReader) next() (job, error) {
+	for {
+		text, err := jr.r.ReadString('\n')
+		if err != nil && (err != io.EOF || text == "") {
+			if err != io.EOF {
+				err = fmt.Errorf("line %d: %w", jr.line+1, err)
+			}
+			return job{}, err
+		}
+
+		jr.line++
+		if jr.line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		j, err := parseJSONJob([]byte(text))
+		j.line = jr.line
+		if err != nil {
+			// like the other inputs, an invalid line is reported and the
+			// rest still read, which needs it to carry a URL
+			if j.url == "" {
+				j.url = text
+			}
+			return j, fmt.Errorf("line %d: %w", jr.line, err)
+		}
+		return j, nil
+	}
+}
+
+func parseJSONJob(data []byte) (job, error) {
+	var v jsonJob
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		var loose struct {
+			URL string `json:"url"`
+		}
+		_ = json.Unmarshal(data, &loose)
+		return job{url: strings.TrimSpace(loose.URL)}, err
+	}
+
+	j := job{url: strings.TrimSpace(v.URL)}
+	if j.url == "" {
+		return j, fmt.Errorf("no url")
+	}
+	if _, err := url.Parse(j.url); err != nil {
+		return j, err
+	}
+
+	if v.Width < 0 || v.Height < 0 {
+		return j, fmt.Errorf("invalid size %dx%d", v.Width, v.Height)
+	}
+	if v.Delay != nil && *v.Delay < 0 {
+		return j, fmt.Errorf("invalid delay %d", *v.Delay)
+	}
+	if v.WaitForTimeout != "" {
+		d, err := time.ParseDuration(v.WaitForTimeout)
+		if err != nil || d <= 0 {
+			return j, fmt.Errorf("invalid waitForTimeout %q", v.WaitForTimeout)
+		}
+		j.options.waitForTimeout = d
+	}
+
+	j.options.width = v.Width
+	j.options.height = v.Height
+	j.options.delay = v.Delay
+	j.options.waitForFunction = v.WaitFor
+	j.options.disableJS = v.DisableJS
+	j.options.headers = v.Headers
+	j.options.cookies = v.Cookies
+	j.options.style = v.Style
+	j.options.script = v.Script
+	j.options.tags = v.Tags
+	if len(v.Params) > 0 {
+		j.options.params = make(map[string]string, len(v.Params))
+		for k, p := range v.Params {
+			switch p := p.(type) {
+			case string:
+				j.options.params[k] = p
+			case float64, bool:
+				data, _ := json.Marshal(p)
+				j.options.params[k] = string(data)
+			default:
+				return j, fmt.Errorf("param %s must be a string, number or boolean", k)
+			}
+		}
+	}
+
+	if err := j.options.setFormat(v.Format); err != nil {
+		return j, err
+	}
+	if err := j.options.setFileName(v.FileName); err != nil {
+		return j, err
+	}
+	return j, nil
+}
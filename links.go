@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxPageSize bounds how much HTML -checkLinks reads from a page.
+const maxPageSize = 5 << 20
+
+// linkClient fetches the pages and assets -checkLinks probes, which unlike
+// renders have no reason to take long.
+var linkClient = &http.Client{Timeout: 30 * time.Second}
+
+// assetError is a subresource of a page that could not be loaded.
+type assetError struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// checkPage fetches the HTML of u and returns its outbound links and the
+// images, scripts and stylesheets it references that fail to load. The page
+// is fetched directly rather than through the renderer, so resources added by
+// scripts at runtime are not seen. Every request first waits for throttle.
+func checkPage(client *http.Client, u string, throttle func(u string) error) ([]string, []assetError, error) {
+	if err := throttle(u); err != nil {
+		return nil, nil, err
+	}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return nil, nil, fmt.Errorf("page returned %s", resp.Status)
+	}
+
+	base := resp.Request.URL
+	links, assets := pageReferences(io.LimitReader(resp.Body, maxPageSize), base)
+	return links, checkAssets(client, assets, throttle), nil
+}
+
+// pageReferences collects the absolute http(s) URLs of links and of assets
+// in an HTML document, each once and in document order.
+func pageReferences(r io.Reader, base *url.URL) (links, assets []string) {
+	seen := map[string]bool{}
+	add := func(list *[]string, ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return
+		}
+		parsed, err := base.Parse(ref)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return
+		}
+		parsed.Fragment = ""
+		if abs := parsed.String(); !seen[abs] {
+			seen[abs] = true
+			*list = append(*list, abs)
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links, assets
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Key] = a.Val
+			}
+
+			switch t.Data {
+			case "base":
+				if href, err := base.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+					base = href
+				}
+			case "a":
+				add(&links, attrs["href"])
+			case "img", "script", "source", "video", "audio":
+				add(&assets, attrs["src"])
+			case "link":
+				rel := strings.ToLower(attrs["rel"])
+				if strings.Contains(rel, "stylesheet") || strings.Contains(rel, "icon") {
+					add(&assets, attrs["href"])
+				}
+			}
+		}
+	}
+}
+
+// checkAssets probes every asset with a HEAD request, falling back to GET for
+// servers that don't allow HEAD, a few at a time. Failures are returned in
+// document order.
+func checkAssets(client *http.Client, assets []string, throttle func(u string) error) []assetError {
+	results := make([]*assetError, len(assets))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, 8)
+
+	for i, a := range assets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, a string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			status, err := 0, throttle(a)
+			if err == nil {
+				status, err = probeAsset(client, a)
+			}
+			if err == nil && status < 400 {
+				return
+			}
+
+			results[i] = &assetError{URL: a, StatusCode: status}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, a)
+	}
+
+	wg.Wait()
+
+	var failed []assetError
+	for _, e := range results {
+		if e != nil {
+			failed = append(failed, *e)
+		}
+	}
+	return failed
+}
+
+func probeAsset(client *http.Client, a string) (int, error) {
+	resp, err := client.Head(a)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		var req *http.Request
+		if req, err = http.NewRequest("GET", a, nil); err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		resp, err = client.Do(req)
+	}
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// Capture backends selected with -backend.
+const (
+	backendServer = "server"
+	backendLocal  = "local"
+)
+
+// localRenderTimeout bounds a single capture in the local browser on top of
+// its delay and -waitForFunctionTimeout, unless -httpTimeout is set.
+const localRenderTimeout = time.Minute
+
+// localCapabilities is what -backend local supports: everything except
+// per-capture proxies, which Chrome only takes when it is launched.
+var localCapabilities = &capabilities{
+	Version: backendLocal,
+	Parameters: []string{
+		"TimeoutSeconds", "FileName", "Url", "Width", "Height", "DisableJavaScript",
+		"Headers", "Cookies", "WaitForFunction", "WaitForFunctionTimeoutSeconds",
+		"Style", "Script", "InitScript", "ScrollPercent", "FrameSelector",
+	},
+}
+
+// localBrowser takes screenshots in a headless Chrome started by the tool,
+// so no screenshot server is needed. Every capture gets its own tab.
+type localBrowser struct {
+	ctx    context.Context
+	cancel func()
+}
+
+// newLocalBrowser starts headless Chrome, from execPath or the first browser
+// found on the PATH when execPath is empty.
+func newLocalBrowser(execPath string) (*localBrowser, error) {
+	opts := chromedp.DefaultExecAllocatorOptions[:]
+	if execPath != "" {
+		opts = append(opts, chromedp.ExecPath(execPath))
+	}
+
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, cancelBrowser := chromedp.NewContext(allocCtx)
+	b := &localBrowser{ctx: browserCtx, cancel: func() { cancelBrowser(); cancelAlloc() }}
+
+	// the first Run launches the browser
+	if err := chromedp.Run(browserCtx); err != nil {
+		b.cancel()
+		return nil, fmt.Errorf("can't start Chrome: %w", err)
+	}
+	return b, nil
+}
+
+func (b *localBrowser) close() {
+	b.cancel()
+}
+
+// render takes a screenshot of u in a new tab and spools it like the server
+// backend does. The status code is the one the page itself was served with.
+// The tab runs in the browser's context; ctx only tells where the image
+// goes.
+func (b *localBrowser) render(ctx context.Context, runOptions *runOptions, u, fileName string, opts captureOptions) (*rendered, error) {
+	if d := runOptions.server.domainFor(u); d != nil {
+		runOptions.politeness.wait(hostOf(u), d.Interval)
+	}
+	if err := runOptions.hostLimiter.wait(b.ctx, hostOf(u)); err != nil {
+		return nil, err
+	}
+
+	timeout := runOptions.client.Timeout
+	if timeout == 0 {
+		timeout = localRenderTimeout + time.Duration(opts.delay)*time.Second + opts.waitForTimeout
+	}
+	tabCtx, cancelTab := chromedp.NewContext(b.ctx)
+	defer cancelTab()
+	tabCtx, cancelTimeout := context.WithTimeout(tabCtx, timeout)
+	defer cancelTimeout()
+
+	renderStart := time.Now()
+	out := &rendered{fileName: fileName, contentType: contentTypes[opts.format]}
+	defer func() { runOptions.usage.add(out.bytes, time.Since(renderStart)) }()
+
+	if err := chromedp.Run(tabCtx, b.prepare(u, opts)...); err != nil {
+		return out, fmt.Errorf("can't prepare the browser: %w", err)
+	}
+
+	resp, err := chromedp.RunResponse(tabCtx, chromedp.Navigate(u))
+	if err != nil {
+		return out, fmt.Errorf("can't load page: %w", err)
+	}
+	if resp != nil {
+		out.statusCode = int(resp.Status)
+	}
+
+	var image []byte
+	if err = chromedp.Run(tabCtx, b.capture(opts, &image)...); err != nil {
+		return out, fmt.Errorf("can't take screenshot: %w", err)
+	}
+	if limit := runOptions.maxImageSize; limit > 0 && int64(len(image)) > limit {
+		return out, fmt.Errorf("%w: screenshot has %d bytes, limit is %d", errOversized, len(image), limit)
+	}
+
+	return out, spool(ctx, runOptions, out, bytes.NewReader(image), int64(len(image)))
+}
+
+// prepare sets up the tab before the page is loaded.
+func (b *localBrowser) prepare(u string, opts captureOptions) []chromedp.Action {
+	actions := []chromedp.Action{
+		emulation.SetDeviceMetricsOverride(int64(opts.width), int64(opts.height), 1, false),
+		network.Enable(),
+	}
+	if opts.disableJS {
+		actions = append(actions, emulation.SetScriptExecutionDisabled(true))
+	}
+	if len(opts.headers) > 0 {
+		headers := network.Headers{}
+		for k, v := range opts.headers {
+			headers[k] = v
+		}
+		actions = append(actions, network.SetExtraHTTPHeaders(headers))
+	}
+	for name, value := range opts.cookies {
+		actions = append(actions, network.SetCookie(name, value).WithURL(u))
+	}
+	if opts.initScript != "" {
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			_, err := page.AddScriptToEvaluateOnNewDocument(opts.initScript).Do(ctx)
+			return err
+		}))
+	}
+	return actions
+}
+
+// capture runs the injections, waits and scrolls as requested and takes the
+// screenshot of the viewport or of the selected frame.
+func (b *localBrowser) capture(opts captureOptions, image *[]byte) []chromedp.Action {
+	var actions []chromedp.Action
+	if opts.style != "" {
+		style, _ := json.Marshal(opts.style)
+		actions = append(actions, chromedp.Evaluate(fmt.Sprintf(
+			`(() => { const s = document.createElement("style"); s.textContent = %s; document.head.appendChild(s); })()`, style), nil))
+	}
+	if opts.script != "" && !opts.disableJS {
+		actions = append(actions, chromedp.Evaluate(opts.script, nil))
+	}
+	if opts.waitForFunction != "" && !opts.disableJS {
+		actions = append(actions, chromedp.Poll(opts.waitForFunction, nil, chromedp.WithPollingTimeout(opts.waitForTimeout)))
+	}
+	if opts.delay > 0 {
+		actions = append(actions, chromedp.Sleep(time.Duration(opts.delay)*time.Second))
+	}
+	if opts.scrollPercent >= 0 {
+		actions = append(actions, chromedp.Evaluate(fmt.Sprintf(
+			`window.scrollTo(0, (document.documentElement.scrollHeight - window.innerHeight) * %d / 100)`, opts.scrollPercent), nil))
+	}
+
+	return append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+		shot := page.CaptureScreenshot().WithFormat(page.CaptureScreenshotFormat(opts.format))
+		if opts.format != "png" {
+			shot = shot.WithQuality(90)
+		}
+
+		if opts.frameSelector != "" {
+			clip, err := elementClip(ctx, opts.frameSelector)
+			if err != nil {
+				return err
+			}
+			shot = shot.WithClip(clip).WithCaptureBeyondViewport(true)
+		}
+
+		var err error
+		*image, err = shot.Do(ctx)
+		return err
+	}))
+}
+
+// elementClip returns the page area of the first element matching selector.
+func elementClip(ctx context.Context, selector string) (*page.Viewport, error) {
+	sel, _ := json.Marshal(selector)
+	var box *struct{ X, Y, Width, Height float64 }
+	err := chromedp.Evaluate(fmt.Sprintf(`(() => {
+    const el = document.querySelector(%s);
+    if (!el) return null;
+    const r = el.getBoundingClientRect();
+    return {x: r.left + window.scrollX, y: r.top + window.scrollY, width: r.width, height: r.height};
+})()`, sel), &box).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if box == nil || box.Width == 0 || box.Height == 0 {
+		return nil, fmt.Errorf("no visible element matches frame selector %q", selector)
+	}
+	return &page.Viewport{X: box.X, Y: box.Y, Width: box.Width, Height: box.Height, Scale: 1}, nil
+}
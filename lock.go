@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFileName = ".screenshoter.lock"
+
+// outputLock is an advisory lock file that keeps overlapping runs (e.g. a
+// slow cron job and its successor) from writing into the same directory.
+type outputLock struct {
+	path string
+	// owner is what the lock file says while this run holds it.
+	owner string
+}
+
+// acquireOutputLock takes the lock in dir. If another run holds it, wait
+// polls until it is released and force takes it over regardless.
+func acquireOutputLock(dir string, wait, force bool, logger *log.Logger) (*outputLock, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lockPath := filepath.Join(dir, lockFileName)
+	if force {
+		if holder, err := os.ReadFile(lockPath); err == nil {
+			logger.Printf("taking over lock %s held by %s", lockPath, holder)
+			os.Remove(lockPath)
+		}
+	}
+
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			owner := fmt.Sprintf("pid %d since %s", os.Getpid(), time.Now().Format(time.RFC3339Nano))
+			fmt.Fprint(f, owner)
+			f.Close()
+			return &outputLock{path: lockPath, owner: owner}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, _ := os.ReadFile(lockPath)
+		if !wait {
+			return nil, fmt.Errorf("%s is locked by another run (%s); use -wait or -force", dir, holder)
+		}
+
+		logger.Printf("waiting for %s to be unlocked (held by %s)", dir, holder)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// release removes the lock unless another run has taken it over with -force
+// in the meantime.
+func (l *outputLock) release() {
+	if holder, err := os.ReadFile(l.path); err == nil && string(holder) == l.owner {
+		os.Remove(l.path)
+	}
+}
+
+// localDirs returns the local directories a storage writes into.
+func localDirs(s storage) []string {
+	switch v := s.(type) {
+	case *localStorage:
+		return []string{v.dir}
+	case *teeStorage:
+		var dirs []string
+		for _, target := range v.targets {
+			dirs = append(dirs, localDirs(target)...)
+		}
+		return dirs
+	}
+	return nil
+}
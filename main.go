@@ -1,8 +1,12 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -10,22 +14,31 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/semaphore"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 
 	"github.com/google/uuid"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/eubelov/screenshoter/screenshoter"
 )
 
 type imageFormat struct {
-	format string
+	format      string
+	transcodeTo []string
 }
 
 type runOptions struct {
+	runID           string
 	width           int
 	height          int
 	inputFilePath   string
@@ -34,42 +47,255 @@ type runOptions struct {
 	postfix         string
 	useQueryParam   string
 	sem             *semaphore.Weighted
+	uploads         *semaphore.Weighted
+	pending         *semaphore.Weighted
+	pendingSize     int64
+	client          *http.Client
+	browser         *localBrowser
+	servers         *serverPool
 	server          *config
+	storage         storage
+	spoolDir        string
+	streaming       bool
+	encryption      cipher.AEAD
+	sinks           []resultSink
+	capabilities    *capabilities
+	inflight        singleflight.Group
+	usage           *usage
+	quota           quota
+	maxImageSize    int64
+	memory          *memoryBudget
+	slow            *slowWatchdog
+	deduper         *urlDeduper
+	filter          *urlFilter
+	shard           *shard
+	window          inputWindow
+	guard           *failureGuard
+	adaptive        *adaptiveConcurrency
+	checkpoint      *checkpoint
+	proxies         *proxyPool
+	schedule        *schedule
+	retries         int
+	retryBackoff    time.Duration
+	fallbacks       []fallbackStep
+	politeness      politeness
+	hostLimiter     *hostLimiter
+	// dispatchRate caps how many URLs are dispatched per second (-rps).
+	dispatchRate    *rate.Limiter
+	rewriter        *rewriter
+	namePolicy      namePolicy
+	dismissBanners  bool
+	disableAnims    bool
+	positions       []int
+	frameSelectors  stringList
+	checkLinks      bool
+	environments    []environment
+	diffThreshold   float64
+	flakiness       *flakiness
+	masks           maskSet
+	baseline        *baseline
+	tickets         *tickets
+	signer          signer
+	objectMetadata  map[string]string
+	signExpiry      time.Duration
+	waitForFunction string
+	waitForTimeout  time.Duration
+	freezeTime      time.Time
+	seedRandom      int64
 	imageFormat
+	redactor
 }
 
 type config struct {
-	Server struct {
-		Host       string `yaml:"host"`
-		Port       int    `yaml:"port"`
-		PingPath   string `yaml:"pingPath"`
-		ActionPath string `yaml:"actionPath"`
-	} `yaml:"server"`
+	Server serverConfig `yaml:"server"`
+	// Servers replaces Server to spread renders over several servers
+	// according to Balance: roundRobin, leastInFlight or latency.
+	Servers  []serverConfig   `yaml:"servers"`
+	Balance  string           `yaml:"balance"`
+	Domains  []domainOverride `yaml:"domains"`
+	Rewrite  rewriteConfig    `yaml:"rewrite"`
+	Schedule scheduleConfig   `yaml:"schedule"`
+	SFTP     sftpConfig       `yaml:"sftp"`
+	// Environments are compared with -compareEnvironments.
+	Environments []environment `yaml:"environments"`
+	// Tickets opens issues for the changes diff and -compareEnvironments find.
+	Tickets ticketConfig `yaml:"tickets"`
+	// Pricing is what -estimate computes costs with.
+	Pricing pricing `yaml:"pricing"`
 }
 
 var (
-	ctx           = context.TODO()
-	width         = flag.Int("width", 1024, "Width of a screenshot")
-	height        = flag.Int("height", 768, "Height of a screenshot")
-	delay         = flag.Int("delay", 0, "Delay between full page load & taking a screenshot")
-	filePath      = flag.String("file", "", "Absolute path to a file with URLs")
-	outputPath    = flag.String("outputDir", "", "Output directory")
-	postfix       = flag.String("postfix", "", "postfix")
-	format        = flag.String("imageFormat", "jpeg", "Format of a screenshot (jpeg or png)")
-	useQueryParam = flag.String("useQueryParam", "", "Use query parameter as file name")
-	concurrency   = flag.Int("concurrency", 2, "Number of concurrent requests")
+	ctx            = context.TODO()
+	width          = flag.Int("width", 1024, "Width of a screenshot")
+	height         = flag.Int("height", 768, "Height of a screenshot")
+	delay          = flag.Int("delay", 0, "Delay between full page load & taking a screenshot")
+	filePath       = flag.String("file", "", "Absolute path to a file with URLs, an http(s) URL, an s3:// or gs:// object (.gz lists are decompressed), a sheets://<id>/<range> Google Sheet, or a sitemap or feed named .xml, .rss or .atom; .csv lists have a url column and optional width, height, delay, filename and format columns, .jsonl lines are objects with url, width, height, delay, filename, format, waitFor, waitForTimeout, disableJS, headers, cookies, style, script, tags and params; .csv param.<Name> columns and .jsonl params are sent to the server as they are")
+	stateFile      = flag.String("stateFile", "", "File recording finished URLs so an interrupted run can be resumed (empty = off)")
+	resume         = flag.Bool("resume", false, "Skip URLs that -stateFile records as saved by a previous, interrupted run")
+	sitemap        = flag.String("sitemap", "", "Sitemap to capture every page of instead of -file, as a path, http(s) URL or s3:// or gs:// object; sitemap indexes are followed")
+	feed           = flag.String("feed", "", "RSS or Atom feed whose item links to capture instead of -file, as a path, http(s) URL or s3:// or gs:// object")
+	feedItems      = flag.Int("feedItems", 0, "Capture only the newest N items of a feed (0 = all)")
+	estimate       = flag.Bool("estimate", false, "Log the cost of the run, from the pricing section of config.yaml, before it starts and once it is done")
+	maxCost        = flag.Float64("maxCost", 0, "Don't start a run whose -estimate exceeds this cost (0 = no limit)")
+	offset         = flag.Int("offset", 0, "Skip the first N URLs of the input")
+	limit          = flag.Int("limit", 0, "Capture at most N URLs of the input, after -offset (0 = all)")
+	sample         = flag.Int("sample", 0, "Capture a random sample of N URLs of the input, after -offset and -limit (0 = all)")
+	shardOf        = flag.String("shard", "", "Capture only slice i of n of the input, e.g. 2/5, so n machines given the same input capture it once between them")
+	dedupURLs      = flag.Bool("dedupURLs", false, "Canonicalize input URLs (lowercase host, no default port) and skip the ones listed before")
+	stripTracking  = flag.Bool("stripTracking", false, "With -dedupURLs, also remove utm_* and click ID tracking parameters from input URLs")
+	crawl          = flag.Bool("crawl", false, "Also capture the pages of each input URL's site it links to, up to -depth links away")
+	crawlDepth     = flag.Int("depth", 1, "How many links -crawl follows from an input URL (0 = only the input URLs)")
+	follow         = flag.Bool("follow", false, "Keep watching -file like tail -f and capture URLs as they are appended, until interrupted")
+	inputCacheAt   = flag.String("inputCache", "", "File remembering the ETag/Last-Modified of an http(s) -file; the run is skipped while the list is unchanged")
+	outputPath     = flag.String("outputDir", "", "Output directory")
+	postfix        = flag.String("postfix", "", "postfix")
+	format         = flag.String("imageFormat", "jpeg", "Format of a screenshot (jpeg, png or webp); a comma-separated list saves every format from a single render")
+	useQueryParam  = flag.String("useQueryParam", "", "Use query parameter as file name")
+	namePlugin     = flag.String("namePlugin", "", "Go plugin (.so) exporting OutputPath(url, metadata) that decides the output path of each capture")
+	concurrency    = flag.Int("concurrency", 2, "Number of concurrent requests")
+	adaptive       = flag.Bool("adaptiveConcurrency", false, "Start at -concurrency and raise it while renders stay fast, backing off when the server slows down or fails them")
+	minConcurrency = flag.Int("minConcurrency", 1, "Lowest concurrency -adaptiveConcurrency backs off to")
+	maxConcurrency = flag.Int("maxConcurrency", 0, "Highest concurrency -adaptiveConcurrency raises to (0 = 4 times -concurrency)")
+	rps            = flag.Float64("rps", 0, "Dispatch at most this many URLs per second over the whole run, to protect a shared screenshot server (0 = no limit)")
+	uploadWorkers  = flag.Int("uploadConcurrency", 0, "Number of screenshots stored at the same time, independently of the renders (0 = same as -concurrency)")
+	uploadQueue    = flag.Int("uploadQueue", 10, "Number of rendered screenshots that may wait for an upload slot before rendering pauses")
+	warmup         = flag.Int("warmup", 0, "Number of throwaway renders sent at half the concurrency before the run, to warm up the renderers")
+	warmupURL      = flag.String("warmupURL", "", "Page rendered by -warmup (default about:blank)")
+	slowAfter      = flag.Duration("slowThreshold", 0, "Warn about captures still running after this long and flag them in the report (0 = off)")
+	slowWebhook    = flag.String("slowWebhook", "", "URL to POST an event to for every capture exceeding -slowThreshold (signed with SCREENSHOTER_WEBHOOK_SECRET)")
+	slowRetry      = flag.Bool("slowRetry", false, "Cancel renders at -slowThreshold and render them once more on another server")
+	httpTimeout    = flag.Duration("httpTimeout", 0, "Timeout of a single request to the screenshot server, including the download (0 = none)")
+	maxIdleConns   = flag.Int("maxIdleConns", 100, "Maximum number of idle keep-alive connections to the screenshot server")
+	idleTimeout    = flag.Duration("idleConnTimeout", 90*time.Second, "How long an idle connection to the screenshot server is kept open")
+	elasticURL     = flag.String("elasticURL", "", "Elasticsearch/OpenSearch URL to index capture results into")
+	elasticIndex   = flag.String("elasticIndex", "screenshots", "Elasticsearch index for capture results")
+	streamResults  = flag.Bool("streamResults", false, "Write every result to stdout as a JSON line as soon as its capture finishes, and the log to stderr")
+	reportPath     = flag.String("report", "", "Path to write the JSON run report to")
+	failedPath     = flag.String("failedFile", "failed.txt", "Path to write failed URLs to, in input format, for re-running them (empty = off)")
+	doneWebhook    = flag.String("completionWebhook", "", "URL to POST the JSON run report to when the batch completes (signed with SCREENSHOTER_WEBHOOK_SECRET)")
+	hookRetries    = flag.Int("webhookRetries", 3, "Number of retries for a failed completion webhook")
+	maxCaptures    = flag.Int64("maxCaptures", 0, "Stop the run after this many renders (0 = unlimited)")
+	maxBytes       = flag.Int64("maxBytes", 0, "Stop the run after downloading this many bytes (0 = unlimited)")
+	maxImageSize   = flag.Int64("maxImageSize", 0, "Fail captures whose image is larger than this many bytes, checked while downloading (0 = unlimited)")
+	sizeOutliers   = flag.Float64("sizeOutlierFactor", 20, "Flag images this many times smaller or larger than the median of their domain in the report, as likely error pages (0 = off)")
+	maxMemory      = flag.Int64("maxMemory", 0, "Bytes of memory captures may use at once for decoded images and upload buffers; captures wait for their share (0 = unlimited)")
+	maxRenderTime  = flag.Duration("maxRenderTime", 0, "Stop the run after this much cumulative render time (0 = unlimited)")
+	encryptKey     = flag.String("encrypt", "", "Encrypt screenshots with the hex-encoded 32-byte key in this file before storing them")
+	redactLogs     = flag.Bool("redactLogs", false, "Replace URLs and file names with hashes in logs, webhooks and result indexes")
+	dedup          = flag.Bool("dedup", false, "Keep identical images once in the output's .objects directory and hard-link the named files to them")
+	waitLock       = flag.Bool("wait", false, "Wait for another run writing to the same output directory to finish")
+	forceLock      = flag.Bool("force", false, "Take over the output directory lock held by another run")
+	fallbacks      = flag.String("fallbacks", "", "Comma-separated fallbacks applied in turn to failing captures: delay, viewport, jpeg, nojs")
+	dismiss        = flag.Bool("dismissBanners", false, "Hide or accept common cookie/consent banners before capture")
+	waitForFunc    = flag.String("waitForFunction", "", "JavaScript expression the renderer waits to become truthy before capturing, e.g. \"window.appReady === true\"")
+	waitForTime    = flag.Duration("waitForFunctionTimeout", 30*time.Second, "How long the renderer waits for -waitForFunction")
+	freezeAt       = flag.String("freezeTime", "", "Freeze the page clock at this RFC 3339 timestamp, e.g. 2024-01-01T12:00:00Z")
+	randomSeed     = flag.Int64("seedRandom", 0, "Seed Math.random in the page for deterministic content (0 = off)")
+	noAnimations   = flag.Bool("disableAnimations", false, "Stop CSS animations, transitions, videos and auto-advancing carousels before capture")
+	positions      = flag.String("capturePositions", "", "Take one screenshot per scroll offset, e.g. 0%,50%,100% (files get a -scrollN suffix)")
+	failureWindow  = flag.Int("failureWindow", 20, "Number of most recent captures the failure rate is measured over")
+	throttleRate   = flag.Float64("throttleFailureRate", 0, "Halve concurrency while the failure rate is at least this, e.g. 0.3 (0 = off)")
+	pauseRate      = flag.Float64("pauseFailureRate", 0, "Pause dispatching for -failurePause when the failure rate reaches this (0 = off)")
+	abortRate      = flag.Float64("abortFailureRate", 0, "Abort the run when the failure rate reaches this (0 = off)")
+	failurePause   = flag.Duration("failurePause", time.Minute, "How long to pause when -pauseFailureRate is reached")
+	hostRate       = flag.Float64("hostRate", 0, "Send each host at most this many requests per second, whatever the -concurrency (0 = no limit)")
+	hostBurst      = flag.Int("hostBurst", 1, "Requests a host may receive at once before -hostRate applies")
+	retries        = flag.Int("retries", 0, "Number of times a capture is retried after a network error, 429 or 5xx")
+	retryBackoff   = flag.Duration("retryBackoff", time.Second, "Wait before the first retry; doubled for every further retry, with jitter")
+	retryBudget    = flag.Int("retryBudget", 0, "Maximum number of retries and fallbacks for the whole run (0 = unlimited)")
+	proxies        = flag.String("proxies", "", "Comma-separated proxies (http://, https:// or socks5://) the renderer fetches pages through; ones with credentials need servers with method POST")
+	proxyRotation  = flag.String("proxyRotation", rotateRoundRobin, "How captures are spread over -proxies: roundrobin, or sticky to keep each domain on one proxy")
+	proxyFailures  = flag.Int("proxyMaxFailures", 3, "Drop a proxy after this many failed renders in a row (0 = never)")
+	checkLinks     = flag.Bool("checkLinks", false, "Fetch each page's HTML and report its links and the images, scripts and stylesheets that fail to load")
+	compareEnvs    = flag.Bool("compareEnvironments", false, "Treat -file lines as paths, capture each in every environment of config.yaml and diff them pairwise")
+	diffThreshold  = flag.Float64("diffThreshold", 0, "Percentage of differing pixels up to which -compareEnvironments treats two shots as equal")
+	flakinessFile  = flag.String("flakinessFile", "", "File tracking how shots change from run to run, to flag flaky URLs and suggest masks for them (empty = off)")
+	flakyRuns      = flag.Int("flakyRuns", 3, "Number of consecutive runs a shot must change in to be flagged as flaky")
+	masksFile      = flag.String("masks", "", "YAML file of per-URL rectangles and selectors blacked out before screenshots are compared")
+	serverFailures = flag.Int("serverMaxFailures", 3, "Take a screenshot server out of rotation after this many failed renders in a row (0 = never)")
+	breakerReset   = flag.Duration("breakerCooldown", 0, "Treat each server as a circuit breaker that opens after -serverMaxFailures failed renders and lets one trial render through after this long (0 = ping-based health checks)")
+	breakerWait    = flag.Bool("breakerWait", false, "While every breaker is open, hold renders until the next trial instead of failing them straight away")
+	healthInterval = flag.Duration("healthCheckInterval", 30*time.Second, "How often servers taken out of rotation are pinged to see whether they are back, and with balance: latency every server to measure its latency (0 = never)")
+	review         = flag.String("review", "", "Post the results as a commit status and pull/merge request comment: github or gitlab (token in GITHUB_TOKEN or GITLAB_TOKEN)")
+	reviewCommit   = flag.String("reviewCommit", "", "Commit to set the -review status on (default: from the CI environment)")
+	reviewRequest  = flag.Int("reviewRequest", 0, "Pull or merge request number to comment on (default: from the CI environment)")
+	reviewTop      = flag.Int("reviewTop", 5, "Number of most changed pages listed in the -review comment (0 = all)")
+	reviewImageURL = flag.String("reviewImageURL", "", "Public URL the output is served from, to inline diff thumbnails in the -review comment")
+	cacheControl   = flag.String("cacheControl", "", "Cache-Control of the objects uploaded to s3://, gs:// and azure:// outputs, e.g. \"public, max-age=31536000\"")
+	signURLs       = flag.Duration("signURLs", 0, "Add a read-only URL valid this long to the results stored on s3://, gs:// or azure:// outputs, for sharing (max 168h, 0 = off)")
+	serverURL      = flag.String("server", "", "Screenshot server to use instead of config.yaml, e.g. http://localhost:5601 (a path sets the actionPath, api/screenshots by default)")
+	backend        = flag.String("backend", backendServer, "Capture backend: server to use the screenshot server of config.yaml, or local to drive headless Chrome directly")
+	chromePath     = flag.String("chromePath", "", "Chrome or Chromium executable for -backend local (default: found on the PATH)")
+	colorMode      = flag.String("color", "auto", "Colorize terminal output: auto, always or never (NO_COLOR is honoured in auto)")
+)
+
+var (
+	outputs        stringList
+	frameSelectors stringList
+	inputHeaders   stringList
+	includeURLs    stringList
+	excludeURLs    stringList
+	metadataPairs  stringList
 )
 
-func main() {
-	logger, logFile := setupLogToFile()
+func init() {
+	flag.Var(&outputs, "output", "Output destination, overrides -outputDir (a directory, file://, zip://, s3://, gs://, azure://, sftp:// or cloudinary://); repeat to write to several at once")
+	flag.Var(&inputHeaders, "inputHeader", "Header sent when fetching an http(s) -file, e.g. \"Authorization: Bearer $TOKEN\" ($VARS are expanded); repeatable")
+	flag.Var(&metadataPairs, "objectMetadata", "Custom key=value metadata set on every uploaded object besides source-url and run-id; repeatable")
+	flag.Var(&includeURLs, "include", "Regular expression input URLs must match to be captured; repeat to allow any of several")
+	flag.Var(&excludeURLs, "exclude", "Regular expression of input URLs to leave out; repeatable")
+	flag.Var(&frameSelectors, "frameSelector", "CSS selector of an iframe to capture instead of the page; repeat for several frames (files get a -frameN suffix)")
+}
+
+// runCapture runs the capture commands once their flags are parsed; diff is
+// a normal capture that is compared against a baseline. The input is that of
+// the flags unless a replay is given. Only a quick capture fails with an
+// error, when its URL couldn't be saved.
+func runCapture(mode captureMode, replayed *replaySource) error {
+	runID := uuid.New().String()
+	logger, logFile := setupLogToFile(runID)
 	defer logFile.Close()
 
 	conf := readConfig(logger)
-	logger.Printf("%+v", *conf)
+	logger.Printf("run %s", runID)
+	logger.Printf("config: %s", conf.summary())
 
-	flag.Parse()
+	for _, alt := range []struct{ name, value string }{{"sitemap", *sitemap}, {"feed", *feed}} {
+		if alt.value == "" {
+			continue
+		}
+		if *filePath != "" {
+			logger.Panicf("-%s replaces -file, set only one of them", alt.name)
+		}
+		*filePath = alt.value
+	}
+
+	if *quickURL != "" {
+		if *filePath != "" {
+			logger.Panicf("a quick capture of %s takes no -file, -sitemap or -feed", *quickURL)
+		}
+		if *estimate || *maxCost > 0 {
+			logger.Panicf("-estimate doesn't apply to a quick capture")
+		}
+		if *quickOutput != "" {
+			if *outputPath != "" || len(outputs) > 0 {
+				logger.Panicf("-o replaces -outputDir and -output, set only one of them")
+			}
+			*outputPath = filepath.Dir(*quickOutput)
+		}
+		// an image only sent to stdout or the clipboard leaves no file behind
+		if (*quickStdout || *quickDataURL || *quickCopy) && *outputPath == "" && len(outputs) == 0 {
+			dir, err := os.MkdirTemp("", "screenshoter-quick-")
+			if err != nil {
+				logger.Panicf("can't create a directory for the capture: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			*outputPath = dir
+		}
+	}
 
 	opt := &runOptions{
+		runID:           runID,
 		width:           *width,
 		height:          *height,
 		delay:           *delay,
@@ -78,27 +304,570 @@ func main() {
 		postfix:         *postfix,
 		useQueryParam:   *useQueryParam,
 		sem:             semaphore.NewWeighted(int64(*concurrency)),
+		client:          newRenderClient(*httpTimeout, *maxIdleConns, *idleTimeout),
 		server:          conf,
-		imageFormat: imageFormat{
-			format: *format,
+		redactor:        redactor{enabled: *redactLogs},
+		dismissBanners:  *dismiss,
+		disableAnims:    *noAnimations,
+		frameSelectors:  frameSelectors,
+		checkLinks:      *checkLinks,
+		waitForFunction: *waitForFunc,
+		waitForTimeout:  *waitForTime,
+		seedRandom:      *randomSeed,
+		usage:           &usage{},
+		maxImageSize:    *maxImageSize,
+		memory:          newMemoryBudget(*maxMemory),
+		retries:         *retries,
+		retryBackoff:    *retryBackoff,
+		quota: quota{
+			maxCaptures:   *maxCaptures,
+			maxBytes:      *maxBytes,
+			maxRenderTime: *maxRenderTime,
 		},
 	}
 
+	imf, err := parseImageFormats(*format)
+	if err != nil {
+		logger.Panicf("invalid -imageFormat: %v", err)
+	}
+	opt.imageFormat = imf
+
+	renderSlots := *concurrency
+	if *adaptive {
+		if *throttleRate > 0 {
+			logger.Panicf("-adaptiveConcurrency and -throttleFailureRate both lower concurrency on failures, use one of them")
+		}
+		renderSlots = *maxConcurrency
+		if renderSlots == 0 {
+			renderSlots = 4 * *concurrency
+		}
+		if opt.adaptive, err = newAdaptiveConcurrency(*concurrency, *minConcurrency, renderSlots); err != nil {
+			logger.Panicf("invalid -adaptiveConcurrency: %v", err)
+		}
+		opt.sem = opt.adaptive.sem
+	}
+
+	// renders and uploads have slots of their own, and the number of URLs
+	// in flight bounds the spooled screenshots waiting in between
+	if *uploadWorkers < 0 || *uploadQueue < 0 {
+		logger.Panicf("-uploadConcurrency and -uploadQueue can't be negative")
+	}
+	uploadSlots := *uploadWorkers
+	if uploadSlots == 0 {
+		uploadSlots = renderSlots
+	}
+	opt.uploads = semaphore.NewWeighted(int64(uploadSlots))
+	opt.pendingSize = int64(renderSlots + uploadSlots + *uploadQueue)
+	opt.pending = semaphore.NewWeighted(opt.pendingSize)
+
+	if opt.fallbacks, err = parseFallbacks(*fallbacks); err != nil {
+		logger.Panicf("invalid -fallbacks: %v", err)
+	}
+
+	if opt.guard, err = newFailureGuard(opt.sem, *failureWindow, *concurrency, *throttleRate, *pauseRate, *abortRate, *failurePause, *retryBudget); err != nil {
+		logger.Panicf("invalid failure guard: %v", err)
+	}
+
+	if *hostRate < 0 {
+		logger.Panicf("-hostRate can't be negative")
+	}
+	opt.hostLimiter = newHostLimiter(*hostRate, *hostBurst)
+	if *rps < 0 {
+		logger.Panicf("-rps can't be negative")
+	}
+	if *rps > 0 {
+		opt.dispatchRate = rate.NewLimiter(rate.Limit(*rps), 1)
+	}
+
+	if opt.proxies, err = newProxyPool(*proxies, *proxyRotation, *proxyFailures); err != nil {
+		logger.Panicf("invalid -proxies: %v", err)
+	}
+	if opt.proxies.hasCredentials() && *backend == backendServer {
+		for _, s := range conf.list() {
+			if !strings.EqualFold(s.Method, http.MethodPost) && s.Protocol != protocolGRPC {
+				logger.Panicf("server %s would get the -proxies credentials in its query string, set its method to POST", s.name())
+			}
+		}
+	}
+
+	if opt.positions, err = parsePositions(*positions); err != nil {
+		logger.Panicf("invalid -capturePositions: %v", err)
+	}
+
+	if *freezeAt != "" {
+		if opt.freezeTime, err = time.Parse(time.RFC3339, *freezeAt); err != nil {
+			logger.Panicf("invalid -freezeTime: %v", err)
+		}
+	}
+
+	if opt.filter, err = newURLFilter(includeURLs, excludeURLs); err != nil {
+		logger.Panicf("invalid -include or -exclude: %v", err)
+	}
+
+	if opt.shard, err = parseShard(*shardOf); err != nil {
+		logger.Panicf("invalid -shard: %v", err)
+	}
+
+	opt.window = inputWindow{offset: *offset, limit: *limit, sample: *sample}
+	if opt.window.offset < 0 || opt.window.limit < 0 || opt.window.sample < 0 {
+		logger.Panicf("-offset, -limit and -sample can't be negative")
+	}
+	if opt.window.sample > 0 && *follow {
+		logger.Panicf("-sample can't be drawn from a followed input")
+	}
+
+	if *dedupURLs {
+		opt.deduper = newURLDeduper(*stripTracking)
+	} else if *stripTracking {
+		logger.Panicf("-stripTracking needs -dedupURLs")
+	}
+
+	if *slowAfter > 0 {
+		opt.slow = &slowWatchdog{threshold: *slowAfter, webhook: *slowWebhook, retry: *slowRetry}
+	} else if *slowWebhook != "" || *slowRetry {
+		logger.Panicf("-slowWebhook and -slowRetry need -slowThreshold")
+	}
+
+	if *namePlugin != "" {
+		if opt.namePolicy, err = loadNamePlugin(*namePlugin); err != nil {
+			logger.Panicf("can't load -namePlugin: %v", err)
+		}
+	}
+
+	if opt.rewriter, err = newRewriter(conf.Rewrite); err != nil {
+		logger.Panicf("invalid rewrite rules in config.yaml: %v", err)
+	}
+
+	if opt.schedule, err = newSchedule(conf.Schedule); err != nil {
+		logger.Panicf("invalid schedule in config.yaml: %v", err)
+	}
+
+	if *compareEnvs {
+		if err = checkEnvironments(conf.Environments); err != nil {
+			logger.Panicf("invalid environments: %v", err)
+		}
+		opt.environments = conf.Environments
+		opt.diffThreshold = *diffThreshold
+	}
+
+	if *masksFile != "" {
+		if opt.masks, err = loadMasks(*masksFile); err != nil {
+			logger.Panicf("can't read -masks: %v", err)
+		}
+	}
+
+	if mode == modeDiff {
+		if *encryptKey != "" {
+			logger.Panicf("diff can't compare encrypted screenshots")
+		}
+		if *bootstrap {
+			if len(outputs) > 0 {
+				logger.Panicf("diff --bootstrap writes to -baselineDir, -output can't be used with it")
+			}
+			opt.outputDirectory = *baselineDir
+			opt.baseline = newBaseline(*baselineDir, runID)
+		} else if opt.baseline, err = openBaseline(*baselineDir); err != nil {
+			logger.Panicf("%v", err)
+		}
+
+		// masks kept with the baseline apply unless -masks says otherwise
+		if masks := filepath.Join(*baselineDir, baselineMasks); *masksFile == "" {
+			if _, err := os.Stat(masks); err == nil {
+				if opt.masks, err = loadMasks(masks); err != nil {
+					logger.Panicf("can't read %s: %v", masks, err)
+				}
+			}
+		}
+		opt.diffThreshold = *diffThreshold
+	}
+
+	if conf.Tickets.Tracker != "" && !replaying {
+		if opt.tickets, err = newTickets(conf.Tickets); err != nil {
+			logger.Panicf("invalid tickets in config.yaml: %v", err)
+		}
+	}
+
+	var reviewer reviewTarget
+	if *review != "" {
+		if reviewer, err = newReviewTarget(*review, *reviewCommit, *reviewRequest); err != nil {
+			logger.Panicf("%v", err)
+		}
+	}
+
+	if *flakinessFile != "" {
+		if opt.flakiness, err = loadFlakiness(*flakinessFile, *flakyRuns); err != nil {
+			logger.Panicf("can't read -flakinessFile: %v", err)
+		}
+	}
+
+	var cache *inputCache
+	if *inputCacheAt != "" {
+		if cache, err = loadInputCache(*inputCacheAt); err != nil {
+			logger.Panicf("can't read -inputCache: %v", err)
+		}
+	}
+
+	// an interrupt ends a -follow run like the end of the input would, so
+	// the report and the state file are still written
+	inputCtx, following := ctx, *follow && mode != modeValidate
+	if following {
+		var stop context.CancelFunc
+		inputCtx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		logger.Printf("following %s for new URLs, interrupt to finish the run", opt.inputFilePath)
+	}
+
+	inputOpts := inputOptions{headers: inputHeaders, cache: cache, follow: following, xml: *sitemap != "" || *feed != "", feedItems: *feedItems}
+	estimating := *estimate || *maxCost > 0
+	if estimating {
+		if following {
+			logger.Panicf("-estimate can't count the URLs of a followed input")
+		}
+		cost, err := estimateCost(opt, conf.Pricing, inputOpts, logger)
+		if err != nil {
+			logger.Panicf("can't estimate the cost of %s: %v", opt.inputFilePath, err)
+		}
+		if *maxCost > 0 && cost > *maxCost {
+			logger.Panicf("estimated cost %s exceeds -maxCost %s", conf.Pricing.format(cost), conf.Pricing.format(*maxCost))
+		}
+	}
+
+	var jobs jobSource
+	switch {
+	case replayed != nil:
+		jobs = replayed
+	case *quickURL != "":
+		jobs, err = newQuickSource(*quickURL, *quickOutput)
+	default:
+		jobs, err = openJobs(inputCtx, opt.inputFilePath, inputOpts)
+	}
+	if errors.Is(err, errInputUnchanged) {
+		logger.Printf("input %s has not changed since the last run, skipping", opt.inputFilePath)
+		return nil
+	}
+	if err != nil {
+		logger.Panicf("can't open input %s: %v", opt.inputFilePath, err)
+	}
+	var sharded *shardSource
+	if opt.shard != nil {
+		sharded = &shardSource{jobs: jobs, shard: opt.shard}
+		jobs = sharded
+	}
+	if *crawl && mode != modeValidate {
+		jobs = newCrawlSource(jobs, *crawlDepth)
+	}
+	var filtered *filteredSource
+	if opt.filter != nil {
+		filtered = &filteredSource{jobs: jobs, filter: opt.filter}
+		jobs = filtered
+	}
+	if closer, ok := jobs.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if mode == modeValidate {
+		validate(opt, conf, jobs, logger)
+		return nil
+	}
+
+	switch len(outputs) {
+	case 0:
+		opt.storage, err = newStorage(opt.outputDirectory, conf)
+	case 1:
+		opt.storage, err = newStorage(outputs[0], conf)
+	default:
+		opt.storage, err = newTeeStorage(outputs, conf)
+	}
+	if err != nil {
+		logger.Panicf("can't set up output: %v", err)
+	}
+	if closer, ok := opt.storage.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				logger.Printf("failed to finalize output: %v", err)
+			}
+		}()
+	}
+
+	if opt.objectMetadata, err = parseMetadata(metadataPairs); err != nil {
+		logger.Panicf("invalid -objectMetadata: %v", err)
+	}
+	opt.objectMetadata["run-id"] = runID
+	if *cacheControl != "" {
+		opt.objectMetadata[metaCacheControl] = *cacheControl
+	}
+
+	if *signURLs > 0 {
+		if *signURLs > maxSignExpiry {
+			logger.Panicf("-signURLs can't exceed %s", maxSignExpiry)
+		}
+		if opt.signer = outputSigner(opt.storage); opt.signer == nil {
+			logger.Panicf("-signURLs needs an s3://, gs:// or azure:// output")
+		}
+		opt.signExpiry = *signURLs
+	}
+
+	if *dedup && *encryptKey != "" {
+		// a fresh nonce makes every encrypted copy of an image differ
+		logger.Panicf("-dedup can't be used with -encrypt")
+	}
+	if *dedup && !enableDedup(opt.storage) {
+		logger.Panicf("-dedup needs a local output directory")
+	}
+
+	for _, dir := range localDirs(opt.storage) {
+		lock, err := acquireOutputLock(dir, *waitLock, *forceLock, logger)
+		if err != nil {
+			logger.Panicf("can't lock output: %v", err)
+		}
+		defer lock.release()
+	}
+
+	if *encryptKey != "" {
+		if opt.encryption, err = readKey(*encryptKey); err != nil {
+			logger.Panicf("can't load encryption key: %v", err)
+		}
+	}
+
+	if *quickStdout || *quickDataURL || *quickCopy {
+		if _, local := opt.storage.(*localStorage); !local || opt.encryption != nil {
+			logger.Panicf("-stdout, -dataURL and -copy read the image back, they need a local output without -encrypt")
+		}
+		if *quickStdout && !*quickDataURL && len(opt.shots("")) > 1 {
+			logger.Panicf("-stdout writes a single image, use -dataURL to print each of the %d shots", len(opt.shots("")))
+		}
+	}
+
+	if opt.checkpoint, err = openCheckpoint(*stateFile, *resume); err != nil {
+		logger.Panicf("can't open -stateFile: %v", err)
+	}
+	defer opt.checkpoint.Close()
+
+	if opt.spoolDir, err = os.MkdirTemp("", "screenshoter-"); err != nil {
+		logger.Panicf("can't create spool directory: %v", err)
+	}
+	defer os.RemoveAll(opt.spoolDir)
+
+	// renders go straight into the output unless something reads them back
+	spooledFor := ""
+	switch {
+	case !streamable(opt.storage):
+		spooledFor = "a zip output"
+	case len(opt.environments) > 0:
+		spooledFor = "-compareEnvironments"
+	case opt.flakiness != nil:
+		spooledFor = "-flakinessFile"
+	case opt.baseline != nil && !opt.baseline.bootstrap:
+		spooledFor = "diff"
+	case len(opt.transcodeTo) > 0:
+		spooledFor = "-imageFormat"
+	}
+	if opt.streaming = spooledFor == ""; !opt.streaming {
+		logger.Printf("spooling renders before storing them for %s", spooledFor)
+	}
+
+	report := newRunReport(runID)
+	report.Usage = opt.usage
+	report.Command = commandLine
+	if report.ConfigFingerprint, err = configFingerprint("config.yaml"); err != nil {
+		logger.Panicf("can't read config.yaml: %v", err)
+	}
+	if replayed != nil {
+		report.ReplayOf = replayed.runID
+	}
+	opt.sinks = append(opt.sinks, report, opt.guard)
+
+	if *failedPath != "" {
+		failed := newFailedList(*failedPath)
+		opt.sinks = append(opt.sinks, failed)
+		defer func() {
+			if err := failed.Close(); err != nil {
+				logger.Printf("failed to write %s: %v", *failedPath, err)
+			}
+		}()
+	}
+
+	if *elasticURL != "" {
+		opt.sinks = append(opt.sinks, newElasticSink(*elasticURL, *elasticIndex, opt.redactor))
+	}
+
+	if *streamResults {
+		opt.sinks = append(opt.sinks, newStreamSink(os.Stdout, opt.redactor))
+	}
+
 	logger.Printf("%+v\n", opt)
-	checkServerAvailable(opt.server, logger)
-	takeScreenshots(opt, logger)
+	defer setupBackend(opt, conf, logger)()
+
+	if err := checkCapabilities(opt, opt.capabilities); err != nil {
+		logger.Panicf("%v", err)
+	}
+
+	if *warmup > 0 {
+		u := *warmupURL
+		if u == "" {
+			u = warmupPage
+		}
+		warmUp(opt, u, *warmup, logger)
+	}
+
+	complete := takeScreenshots(opt, jobs, logger)
+	if sharded != nil {
+		logger.Printf("shard %s: left out %d URLs of the other shards", opt.shard, sharded.skipped)
+	}
+	if filtered != nil && filtered.dropped > 0 {
+		logger.Printf("left out %d URLs filtered by -include and -exclude", filtered.dropped)
+	}
+	report.finish()
+	if estimating {
+		report.Cost = actualCost(opt.usage, conf.Pricing, logger)
+	}
+	for _, res := range report.flagSizeAnomalies(*sizeOutliers) {
+		logger.Printf("%s may be an error page: %d bytes, %s", opt.value(res.FileName), res.Bytes, res.SizeAnomaly)
+	}
+	logger.Printf("run completed: %d saved, %d failed", report.Saved, report.Failed)
+	if report.Skipped > 0 {
+		logger.Printf("%d URLs were skipped", report.Skipped)
+	}
+
+	if opt.baseline != nil && opt.baseline.bootstrap {
+		if err := opt.baseline.save(); err != nil {
+			logger.Printf("failed to write baseline manifest: %v", err)
+		} else if n := opt.baseline.missing(); n > 0 {
+			logger.Printf("baseline written to %s; %d shots could not be captured and will be reported as new", *baselineDir, n)
+		} else {
+			logger.Printf("baseline written to %s", *baselineDir)
+		}
+	} else if opt.baseline != nil {
+		logger.Printf("diff: %d shots differ from the baseline", report.Differences)
+	}
+
+	if reviewer != nil {
+		success, summary := reviewSummary(report)
+		if err := reviewer.setStatus(success, summary); err != nil {
+			logger.Printf("failed to set %s commit status: %v", *review, err)
+		}
+		if err := reviewer.comment(reviewComment(report, opt.redactor, *reviewTop, *reviewImageURL)); err != nil {
+			logger.Printf("failed to comment on %s: %v", *review, err)
+		}
+	}
+
+	if opt.flakiness != nil {
+		if err := opt.flakiness.save(); err != nil {
+			logger.Printf("failed to write %s: %v", *flakinessFile, err)
+		}
+	}
+
+	// a list is only skipped as unchanged once all of it was captured
+	if cache != nil && (!complete || report.Failed > 0 || opt.window.enabled()) {
+		logger.Printf("not updating input cache %s, the run didn't capture all of %s", *inputCacheAt, opt.inputFilePath)
+	} else if cache != nil {
+		if err := cache.save(); err != nil {
+			logger.Printf("failed to write input cache %s: %v", *inputCacheAt, err)
+		}
+	}
+
+	if *reportPath != "" {
+		if err := report.writeFile(*reportPath, opt.encryption); err != nil {
+			logger.Printf("failed to write report %s: %v", *reportPath, err)
+		}
+		if opt.redactor.enabled && opt.encryption == nil {
+			logger.Printf("warning: report %s holds unredacted URLs; use -encrypt to protect it", *reportPath)
+		}
+	}
+
+	if *doneWebhook != "" {
+		body, err := report.marshalFor(opt.redactor)
+		if err == nil {
+			err = postWebhook(*doneWebhook, os.Getenv("SCREENSHOTER_WEBHOOK_SECRET"), body, *hookRetries, logger)
+		}
+		if err != nil {
+			logger.Printf("completion webhook %s failed: %v", *doneWebhook, err)
+		}
+	}
+
+	if *quickURL != "" {
+		return quickResult(report)
+	}
+	return nil
+}
+
+// setupBackend connects opt to the capture backend of -backend and returns
+// what to call once the run is over.
+func setupBackend(opt *runOptions, conf *config, logger *log.Logger) func() {
+	var err error
+	switch *backend {
+	case backendServer:
+		if opt.servers, err = newServerPool(conf, *serverFailures, *breakerReset, *breakerWait, logger); err != nil {
+			logger.Panicf("invalid servers in config.yaml: %v", err)
+		}
+		if err = opt.servers.checkAll(); err != nil {
+			logger.Panicf("%v", err)
+		}
+		opt.servers.probe(*healthInterval)
+		opt.capabilities = probeServers(opt.servers.available(), logger)
+		return opt.servers.stop
+	case backendLocal:
+		if opt.browser, err = newLocalBrowser(*chromePath); err != nil {
+			logger.Panicf("%v", err)
+		}
+		opt.capabilities = localCapabilities
+		logger.Printf("capturing with a local headless Chrome")
+		return opt.browser.close
+	}
+	logger.Panicf("unknown -backend %q, want %s or %s", *backend, backendServer, backendLocal)
+	return nil
 }
 
-func setupLogToFile() (l *log.Logger, f *os.File) {
+// validate is the validate command: with the options set up, it probes the
+// backend and reads the whole input, failing on any invalid line.
+func validate(opt *runOptions, conf *config, jobs jobSource, logger *log.Logger) {
+	defer setupBackend(opt, conf, logger)()
+	if err := checkCapabilities(opt, opt.capabilities); err != nil {
+		logger.Panicf("%v", err)
+	}
+
+	valid, invalid := 0, 0
+	for {
+		j, err := jobs.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = checkRow(opt.capabilities, j.options)
+		}
+		if err != nil {
+			logger.Printf("invalid input %s: %s", opt.inputFilePath, opt.within(err.Error(), j.url))
+			if j.url == "" {
+				break
+			}
+			invalid++
+			continue
+		}
+		valid++
+	}
+	if invalid > 0 {
+		logger.Panicf("%d of %d input lines are invalid", invalid, valid+invalid)
+	}
+	logger.Printf("configuration is valid, %d URLs to capture", valid)
+}
+
+func setupLogToFile(runID string) (l *log.Logger, f *os.File) {
 	_ = os.Mkdir("logs", 0644)
 
-	file, _ := os.OpenFile(fmt.Sprintf("logs/%s.log", uuid.New()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	logger := log.New(io.MultiWriter(os.Stdout, file), "", log.LstdFlags)
+	file, _ := os.OpenFile(fmt.Sprintf("logs/%s.log", runID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	console := os.Stdout
+	if *streamResults || *quickURL != "" {
+		console = os.Stderr
+	}
+	logger := log.New(io.MultiWriter(newConsoleWriter(console, *colorMode), file), "", log.LstdFlags)
 	return logger, file
 }
 
 func readConfig(logger *log.Logger) *config {
 	f, err := os.Open("config.yaml")
+	if os.IsNotExist(err) && (*backend == backendLocal || *serverURL != "") {
+		return withFlagServer(&config{}, logger)
+	}
 	if err != nil {
 		logger.Panicf("config.yaml not found in binary directory: %v", err)
 	}
@@ -110,96 +879,605 @@ func readConfig(logger *log.Logger) *config {
 		logger.Panicf("can't parse config.yaml: %v", err)
 	}
 
-	return &conf
+	return withFlagServer(&conf, logger)
 }
 
-func takeScreenshots(runOptions *runOptions, logger *log.Logger) {
-	if file, err := os.Open(runOptions.inputFilePath); err != nil {
-		logger.Panicf("file does not exist: %s", runOptions.inputFilePath)
-	} else {
-		defer file.Close()
+// summary describes conf for the log without the passwords, headers and
+// cookies config.yaml may hold.
+func (conf *config) summary() string {
+	var names []string
+	for _, s := range conf.list() {
+		names = append(names, s.name())
+	}
+	parts := []string{"servers " + strings.Join(names, ", ")}
+	if conf.Balance != "" {
+		parts = append(parts, "balance "+conf.Balance)
+	}
+	if len(conf.Domains) > 0 {
+		parts = append(parts, fmt.Sprintf("%d domain overrides", len(conf.Domains)))
+	}
+	if len(conf.Environments) > 0 {
+		parts = append(parts, fmt.Sprintf("%d environments", len(conf.Environments)))
+	}
+	if conf.SFTP.Host != "" {
+		parts = append(parts, "sftp "+conf.SFTP.Host)
+	}
+	if conf.Tickets.Tracker != "" && !replaying {
+		parts = append(parts, "tickets in "+conf.Tickets.Tracker)
+	}
+	return strings.Join(parts, ", ")
+}
 
-		scanner := bufio.NewScanner(file)
-		actionURL := fmt.Sprintf("%s:%d/%s", runOptions.server.Server.Host, runOptions.server.Server.Port, runOptions.server.Server.ActionPath)
+// takeScreenshots captures the URLs of jobs, reporting whether it got to the
+// end of the input rather than stopping early.
+func takeScreenshots(runOptions *runOptions, jobs jobSource, logger *log.Logger) (complete bool) {
+	if runOptions.window.enabled() {
+		jobs = &windowSource{jobs: jobs, window: runOptions.window}
+	}
+	skipped, duplicates := 0, 0
+	var outside error
+	for {
+		j, err := jobs.next()
+		if err == io.EOF {
+			complete = true
+			break
+		}
+		if err == nil {
+			err = checkRow(runOptions.capabilities, j.options)
+		}
+		if err != nil {
+			logger.Printf("skipping input %s: %s", runOptions.inputFilePath, runOptions.within(err.Error(), j.url))
+			if j.url == "" {
+				break
+			}
+			recordResult(runOptions, &captureResult{URL: j.url, Line: j.line, StartedAt: time.Now(), Status: statusFailed, Error: err.Error()}, logger)
+			continue
+		}
 
-		for scanner.Scan() {
-			if err := runOptions.sem.Acquire(ctx, 1); err != nil {
-				logger.Printf("failed to acquire semaphore: %v", err)
+		var first int
+		var duplicate bool
+		if j.url, first, duplicate = runOptions.deduper.admit(j.url, j.line); duplicate {
+			duplicates++
+			reason := "duplicate URL"
+			if first > 0 {
+				reason = fmt.Sprintf("duplicate of line %d", first)
 			}
+			recordResult(runOptions, &captureResult{URL: j.url, Line: j.line, StartedAt: time.Now(), Status: statusSkipped, Error: reason}, logger)
+			continue
+		}
+
+		if runOptions.checkpoint.completed(j.url) {
+			skipped++
+			continue
+		}
 
-			url := scanner.Text()
+		// once outside the capture window, the rest of the input is
+		// reported as skipped rather than captured
+		if outside == nil {
+			if outside = runOptions.schedule.admit(logger); outside != nil {
+				logger.Printf("skipping from line %d on: %v", j.line, outside)
+			}
+		}
+		if outside != nil {
+			recordResult(runOptions, &captureResult{URL: j.url, Line: j.line, StartedAt: time.Now(), Status: statusSkipped, Error: outside.Error()}, logger)
+			continue
+		}
 
-			go saveImage(runOptions, actionURL, url, logger)
+		if err := runOptions.guard.admit(logger); err != nil {
+			logger.Printf("aborting at line %d: %v", j.line, err)
+			break
 		}
+		runOptions.adaptive.adjust(logger)
 
-		if err := runOptions.sem.Acquire(ctx, int64(*concurrency)); err != nil {
+		if err := runOptions.pending.Acquire(ctx, 1); err != nil {
 			logger.Printf("failed to acquire semaphore: %v", err)
 		}
+
+		if err := runOptions.usage.exceeded(runOptions.quota); err != nil {
+			runOptions.pending.Release(1)
+			logger.Printf("stopping at line %d: %v", j.line, err)
+			break
+		}
+
+		if runOptions.dispatchRate != nil {
+			if err := runOptions.dispatchRate.Wait(ctx); err != nil {
+				runOptions.pending.Release(1)
+				logger.Printf("stopping at line %d: %v", j.line, err)
+				break
+			}
+		}
+
+		go saveImage(runOptions, j, logger)
+	}
+
+	runOptions.guard.stop()
+	runOptions.adaptive.stop()
+	if skipped > 0 {
+		logger.Printf("resumed: skipped %d URLs saved by the previous run", skipped)
+	}
+	if duplicates > 0 {
+		logger.Printf("skipped %d duplicate URLs", duplicates)
+	}
+
+	if err := runOptions.pending.Acquire(ctx, runOptions.pendingSize); err != nil {
+		logger.Printf("failed to acquire semaphore: %v", err)
 	}
+	return complete
 }
 
-func saveImage(runOptions *runOptions, host, u string, logger *log.Logger) {
-	start := time.Now()
+func saveImage(runOptions *runOptions, j job, logger *log.Logger) {
+	defer runOptions.pending.Release(1)
 
-	logger.Printf("processing %s", u)
+	status := statusSaved
+	shots := runOptions.shots(uuid.New().String())
+	results := make([]*captureResult, len(shots))
+	for i, s := range shots {
+		if results[i] = saveShot(runOptions, j, s, i == 0, logger); results[i].Status != statusSaved {
+			status = statusFailed
+		}
+	}
+	if len(runOptions.environments) > 0 {
+		compareEnvironments(runOptions, j, shots, results, logger)
+	}
 
-	var fileName string
-	defer runOptions.sem.Release(1)
+	if err := runOptions.checkpoint.mark(j, status); err != nil {
+		logger.Printf("failed to record %s in -stateFile: %v", runOptions.value(j.url), err)
+	}
+}
 
-	if runOptions.useQueryParam != "" {
-		parsedURL, _ := url.Parse(u)
-		fn := parsedURL.Query().Get(runOptions.useQueryParam)
-		if fn != "" {
-			fileName = fmt.Sprintf("%s%s.%s", fn, runOptions.postfix, runOptions.format)
+// saveShot takes one shot of j. The page-level checks are only done with the
+// first shot of a URL.
+func saveShot(runOptions *runOptions, j job, s shot, first bool, logger *log.Logger) (res *captureResult) {
+	u := j.url
+	if s.env != nil {
+		u = s.env.url(u)
+	}
+	res = &captureResult{URL: u, Line: j.line, Tags: j.options.tags, CaptureID: uuid.New().String(), StartedAt: time.Now(), Status: statusFailed}
+	if s.scrollPercent >= 0 {
+		res.ScrollPercent = &s.scrollPercent
+	}
+	res.FrameSelector = s.frameSelector
+	if s.env != nil {
+		res.Environment = s.env.Name
+		res.OriginalURL = j.url
+	}
+	defer recordResult(runOptions, res, logger)
+	defer runOptions.slow.watch(runOptions, res, logger)()
+	if runOptions.baseline != nil && runOptions.baseline.bootstrap {
+		defer func() { runOptions.baseline.record(u, s, res) }()
+	}
+
+	if runOptions.rewriter.enabled() {
+		rewritten, err := runOptions.rewriter.rewrite(u)
+		if err != nil {
+			res.Error = err.Error()
+			logger.Printf("failed to rewrite %s: %s", runOptions.value(u), runOptions.within(err.Error(), u))
+			return
+		}
+		if rewritten != u {
+			if res.OriginalURL == "" {
+				res.OriginalURL = u
+			}
+			res.URL, u = rewritten, rewritten
 		}
 	}
-	if fileName == "" {
-		fileName = fmt.Sprintf("%s%s.%s", uuid.New(), runOptions.postfix, runOptions.format)
+
+	logger.Printf("processing %s (capture %s)", runOptions.value(u), res.CaptureID)
+
+	if first && runOptions.checkLinks {
+		var err error
+		if res.Links, res.AssetErrors, err = checkPage(linkClient, u, func(ref string) error { return runOptions.throttle(ctx, ref) }); err != nil {
+			logger.Printf("failed to check links of %s: %s", runOptions.value(u), runOptions.within(err.Error(), u))
+		} else if len(res.AssetErrors) > 0 {
+			logger.Printf("%s has %d broken assets", runOptions.value(u), len(res.AssetErrors))
+		}
 	}
 
-	formData := url.Values{
-		"TimeoutSeconds": {strconv.Itoa(runOptions.delay)},
-		"FileName":       {fileName},
-		"Url":            {u},
-		"Width":          {strconv.Itoa(runOptions.width)},
-		"Height":         {strconv.Itoa(runOptions.height)},
-	}.Encode()
+	if err := capture(runOptions, u, j.options, s, res, logger); err != nil {
+		res.Error = err.Error()
+		logger.Printf("failed to capture %s (capture %s): %s", runOptions.value(u), res.CaptureID, runOptions.within(err.Error(), u, res.FileName))
+		return
+	}
+
+	logger.Printf("saved file %s to %s (capture %s). completed in %s of which %d seconds is a delay", runOptions.value(res.FileName), runOptions.value(res.StoragePath), res.CaptureID, time.Since(res.StartedAt), runOptions.delay)
+	for _, dest := range res.Destinations {
+		if dest.Error != "" {
+			logger.Printf("failed to store %s in %s: %s", runOptions.value(res.FileName), dest.Output, runOptions.within(dest.Error, res.FileName))
+		}
+	}
+	return
+}
+
+// capture requests a screenshot of u and puts it into the configured storage.
+// Any failure is returned to the caller so one bad URL never stops the batch.
+// Identical captures that are in flight at the same time share one render.
+// The render holds one of the -concurrency slots and the upload one of the
+// -uploadConcurrency ones, so a slow storage doesn't idle the renderer. A
+// streaming capture renders on its own and uploads the image as it arrives,
+// holding both.
+func capture(runOptions *runOptions, u string, row jobOptions, s shot, res *captureResult, logger *log.Logger) error {
+	var out *rendered
+	var shared bool
+	var opts captureOptions
+	var renderName string
+	var err error
+
+	base := row.apply(runOptions.captureOptions(u))
+	base.scrollPercent = s.scrollPercent
+	base.frameSelector = s.frameSelector
+	attempts := runOptions.attempts(base)
+	for i, a := range attempts {
+		opts = a.options
+		if i > 0 {
+			if !runOptions.guard.spendRetry() {
+				logger.Printf("not retrying %s (capture %s): retry budget of %d exhausted", runOptions.value(u), res.CaptureID, runOptions.guard.budget)
+				break
+			}
+			logger.Printf("retrying %s (capture %s) with fallback %s: %s", runOptions.value(u), res.CaptureID, strings.Join(a.fallbacks, "+"), runOptions.within(err.Error(), u, res.FileName))
+		}
+
+		// the shot keeps its name when a fallback renders another format
+		if res.FileName, err = outputFileName(runOptions, u, s, row, base.format); err != nil {
+			break
+		}
+		res.Base = strings.TrimSuffix(res.FileName, runOptions.postfix+s.suffix()+"."+base.format)
+		renderName = res.FileName
+		if opts.format != base.format {
+			if renderName, err = outputFileName(runOptions, u, s, row, opts.format); err != nil {
+				break
+			}
+		}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s?%s", host, formData), nil)
+		// wait for the domain's pace before taking a slot, so the wait
+		// doesn't keep a render from another domain
+		if err = runOptions.throttle(ctx, u); err != nil {
+			break
+		}
+		if err = runOptions.sem.Acquire(ctx, 1); err != nil {
+			logger.Printf("failed to acquire semaphore: %v", err)
+		}
+		// a fallback to another format has to be transcoded from the spool
+		if runOptions.streaming && opts.format == base.format {
+			out, err = renderWithRetries(withUpload(context.Background(), u), runOptions, u, renderName, res.CaptureID, opts, logger)
+			shared = false
+		} else {
+			var v interface{}
+			v, err, shared = runOptions.inflight.Do(coalesceKey(u, opts), func() (interface{}, error) {
+				return renderWithRetries(context.Background(), runOptions, u, renderName, res.CaptureID, opts, logger)
+			})
+			out, _ = v.(*rendered)
+		}
+		runOptions.sem.Release(1)
+		if out != nil {
+			res.StatusCode = out.statusCode
+			res.Proxy = out.proxy
+			res.Server = out.server
+			res.Diagnostics = out.diagnostics
+			res.Render = out.info
+		}
+		if err == nil {
+			res.Fallback = strings.Join(a.fallbacks, "+")
+			break
+		}
+	}
 	if err != nil {
-		logger.Panic(err)
-		return
+		res.Oversized = errors.Is(err, errOversized)
+		return err
 	}
 
-	resp, err := client.Do(req)
+	// a shared spool file may still be read by another caller; the spool
+	// directory is removed as a whole at the end of the run
+	if !shared {
+		defer out.discard()
+	}
+
+	res.Bytes = out.bytes
+	res.SHA256 = out.sha256
+	res.Coalesced = shared && out.fileName != renderName
+
+	if out.stored {
+		res.StoragePath, res.Destinations = out.location, out.destinations
+	} else {
+		if err = runOptions.uploads.Acquire(ctx, 1); err != nil {
+			logger.Printf("failed to acquire semaphore: %v", err)
+		}
+		defer runOptions.uploads.Release(1)
+		if opts.format != base.format {
+			err = convert(runOptions, out, u, res)
+		} else {
+			err = storeSpooled(runOptions, out, u, res)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	res.Status = statusSaved
+	if runOptions.signer != nil {
+		if res.SignedURL, err = runOptions.signer.SignURL(storedName(runOptions, res.FileName), runOptions.signExpiry); err != nil {
+			logger.Printf("can't sign a URL for %s: %v", runOptions.value(res.FileName), err)
+		}
+	}
+
+	comparing := runOptions.baseline != nil && !runOptions.baseline.bootstrap
+	if s.env != nil || runOptions.flakiness != nil || comparing {
+		// the shot, the image it is compared with and their diff
+		release := runOptions.memory.reserve(3*out.decodedSize() + uploadBuffers(runOptions.storage))
+		img, err := out.decode()
+		if err != nil {
+			logger.Printf("can't decode %s for comparison: %v", runOptions.value(res.FileName), err)
+		} else {
+			img = runOptions.masks.apply(u, img)
+			if s.env != nil {
+				res.image = img
+			}
+			runOptions.flakiness.observe(res.URL+"|"+s.suffix(), flakySignature(u, opts), img, res)
+			logFlaky(runOptions, res, logger)
+			if comparing {
+				runOptions.baseline.compare(runOptions, u, s, img, res, logger)
+			}
+		}
+		release()
+	}
+
+	formats := runOptions.imageFormat
+	if row.format != "" {
+		formats = imageFormat{format: row.format}
+	}
+	if res.ExtraFiles, err = transcode(runOptions, out, res.FileName, u, formats.extraFormats(base.format)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// storeSpooled puts the spooled image of out into the storage as
+// res.FileName.
+func storeSpooled(runOptions *runOptions, out *rendered, u string, res *captureResult) (err error) {
+	spool, err := out.open()
 	if err != nil {
-		logger.Panic(err)
+		return err
+	}
+
+	defer spool.Close()
+
+	metadata := map[string]string{"content-type": out.contentType, "source-url": u}
+	defer runOptions.memory.reserve(uploadBuffers(runOptions.storage))()
+	res.StoragePath, res.Destinations, err = store(runOptions, res.FileName, spool, metadata)
+	if err != nil {
+		return fmt.Errorf("can't store %s: %w", res.FileName, err)
+	}
+	return nil
+}
+
+// render asks the server for a screenshot of u and spools it to a local file.
+// Cancelling ctx abandons the render, except with -backend local.
+func render(ctx context.Context, runOptions *runOptions, u, fileName, captureID string, opts captureOptions) (*rendered, error) {
+	if runOptions.browser != nil {
+		return runOptions.browser.render(ctx, runOptions, u, fileName, opts)
+	}
+
+	params := opts.library(fileName).Params(u)
+
+	choice := serverChoiceFrom(ctx)
+	srv, err := runOptions.servers.acquire(choice.avoid)
+	if err != nil {
+		return nil, err
+	}
+	defer runOptions.servers.release(srv)
+	choice.used = srv.conf.name()
+
+	if srv.conn != nil {
+		return renderGRPC(ctx, runOptions, srv, params, captureID, opts)
+	}
+
+	client := screenshoter.Client{Server: srv.conf.name(), ActionPath: srv.conf.ActionPath, Post: srv.conf.Method == http.MethodPost, ParamNames: srv.conf.Params}
+	req, err := client.NewRequest(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(runIDHeader, runOptions.runID)
+	req.Header.Set(captureIDHeader, captureID)
+
+	renderStart := time.Now()
+	resp, err := runOptions.client.Do(req)
+	if err != nil {
+		runOptions.servers.report(srv, 0, err)
+		runOptions.usage.add(0, time.Since(renderStart))
+		// the request URL embeds the page URL; keep only the cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = fmt.Errorf("request to server failed: %w", urlErr.Err)
+		}
+		return nil, err
 	}
 
 	defer resp.Body.Close()
 
+	out := &rendered{fileName: fileName, statusCode: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), info: srv.conf.ResultHeaders.parse(resp.Header.Get)}
+	if runOptions.servers.multiple() {
+		out.server = srv.conf.name()
+	}
+	defer func() { runOptions.usage.add(out.bytes, time.Since(renderStart)) }()
+
 	if resp.StatusCode > 299 {
-		return
+		// drain short error bodies so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		err = fmt.Errorf("server returned %s", resp.Status)
+		runOptions.servers.report(srv, resp.StatusCode, err)
+		return out, err
+	}
+	runOptions.servers.report(srv, resp.StatusCode, nil)
+	// servers may label images application/octet-stream, which CDNs then
+	// serve as downloads
+	if !strings.HasPrefix(out.contentType, "image/") {
+		out.contentType = contentTypes[opts.format]
 	}
+	if limit := runOptions.maxImageSize; limit > 0 && resp.ContentLength > limit {
+		return out, fmt.Errorf("%w: server announced %d bytes, limit is %d", errOversized, resp.ContentLength, limit)
+	}
+	err = spool(ctx, runOptions, out, resp.Body, resp.ContentLength)
+	if out.diagnostics != nil {
+		out.diagnostics.Headers = flatHeaders(resp.Header)
+	}
+	return out, err
+}
+
+// flatParams turns the action parameters into the map gRPC sends them as.
+func flatParams(params url.Values) map[string]string {
+	flat := make(map[string]string, len(params))
+	for k := range params {
+		flat[k] = params.Get(k)
+	}
+	return flat
+}
 
-	f, err := os.Create(path.Join(runOptions.outputDirectory, fileName))
+// spool downloads the image of out from body. A render for a capture that
+// streams is piped straight into the output; the others are spooled into
+// the spool directory, or into memory with -encrypt so that the image never
+// reaches the disk unencrypted. An empty or truncated image, judged by
+// expected (-1 when unknown) and its end marker, fails with errIncomplete
+// and the start of the body in out.diagnostics; a streamed one is then
+// abandoned by the output.
+func spool(ctx context.Context, runOptions *runOptions, out *rendered, body io.Reader, expected int64) error {
+	if u, ok := ctx.Value(uploadKey{}).(string); ok {
+		return upload(runOptions, out, u, body, expected)
+	}
+	if runOptions.encryption != nil {
+		var buf bytes.Buffer
+		err := download(runOptions, out, &buf, body, expected)
+		if err == nil {
+			out.data = buf.Bytes()
+		}
+		return err
+	}
+
+	f, err := os.CreateTemp(runOptions.spoolDir, "render-*")
 	if err != nil {
-		os.Remove(f.Name())
-		logger.Panic(err)
+		return err
 	}
 
 	defer f.Close()
-	io.Copy(f, resp.Body)
 
-	logger.Printf("saved file %s. completed in %s of which %d seconds is a delay", fileName, time.Since(start), runOptions.delay)
+	out.path = f.Name()
+	if err = download(runOptions, out, f, body, expected); err != nil {
+		os.Remove(f.Name())
+		out.path = ""
+	}
+	return err
+}
+
+type uploadKey struct{}
+
+// withUpload marks the renders of ctx to be streamed into the output, with
+// u as their source URL.
+func withUpload(ctx context.Context, u string) context.Context {
+	return context.WithValue(ctx, uploadKey{}, u)
 }
 
-func checkServerAvailable(conf *config, logger *log.Logger) {
-	pingPath := fmt.Sprintf("%s:%d/%s", conf.Server.Host, conf.Server.Port, conf.Server.PingPath)
-	if _, err := http.Head(pingPath); err != nil {
-		logger.Panicf("server %s is not available: %v", conf.Server.Host, err)
+// upload stores the image of out as it is downloaded, under out.fileName.
+func upload(runOptions *runOptions, out *rendered, u string, body io.Reader, expected int64) error {
+	if err := runOptions.uploads.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer runOptions.uploads.Release(1)
+	defer runOptions.memory.reserve(uploadBuffers(runOptions.storage))()
+
+	pr, pw := io.Pipe()
+	downloaded := make(chan error, 1)
+	go func() {
+		err := download(runOptions, out, pw, body, expected)
+		pw.CloseWithError(err)
+		downloaded <- err
+	}()
+
+	metadata := map[string]string{"content-type": out.contentType, "source-url": u}
+	location, destinations, err := store(runOptions, out.fileName, pr, metadata)
+	// unblock the download if the output gave up before reading all of it
+	pr.CloseWithError(errDestinationDone)
+	if derr := <-downloaded; derr != nil && !errors.Is(derr, errDestinationDone) {
+		return derr
+	}
+	if err != nil {
+		return fmt.Errorf("can't store %s: %w", out.fileName, err)
+	}
+	out.stored, out.location, out.destinations = true, location, destinations
+	return nil
+}
+
+// download copies body to w, checking that the image is complete.
+func download(runOptions *runOptions, out *rendered, w io.Writer, body io.Reader, expected int64) error {
+
+	// read one byte past the limit so an oversized image is detected without
+	// downloading the rest of it
+	if runOptions.maxImageSize > 0 {
+		body = io.LimitReader(body, runOptions.maxImageSize+1)
+	}
+
+	h := sha256.New()
+	head := &headWriter{}
+	tail := &tailWriter{}
+	var err error
+	out.bytes, err = io.Copy(io.MultiWriter(w, h, head, tail), body)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: download interrupted after %d bytes: %v", errIncomplete, out.bytes, err)
+	case out.bytes == 0:
+		err = fmt.Errorf("%w: server returned an empty body", errIncomplete)
+	case expected >= 0 && out.bytes < expected:
+		err = fmt.Errorf("%w: got %d of %d bytes", errIncomplete, out.bytes, expected)
+	case isText(head.head):
+		err = fmt.Errorf("%w: server returned text instead of an image", errIncomplete)
+	case runOptions.maxImageSize > 0 && out.bytes > runOptions.maxImageSize:
+		err = fmt.Errorf("%w: download stopped after %d bytes", errOversized, runOptions.maxImageSize)
+	default:
+		err = checkComplete(head.head, tail.tail, out.bytes)
+	}
+	if err != nil {
+		if errors.Is(err, errIncomplete) {
+			out.diagnostics = &responseDiagnostics{Snippet: snippet(head.head)}
+		}
+		return err
+	}
+	out.sha256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// outputFileName names a shot of u after, in order of precedence, the file
+// name of its input row, the -namePlugin, the -useQueryParam parameter and
+// the base of the shot.
+func outputFileName(runOptions *runOptions, u string, s shot, row jobOptions, format string) (string, error) {
+	base := s.base
+	if row.fileName != "" {
+		return fmt.Sprintf("%s%s%s.%s", row.fileName, runOptions.postfix, s.suffix(), format), nil
+	}
+	if runOptions.useQueryParam != "" {
+		parsedURL, err := url.Parse(u)
+		if err != nil {
+			return "", fmt.Errorf("invalid url: %w", err)
+		}
+
+		if fn := parsedURL.Query().Get(runOptions.useQueryParam); fn != "" {
+			base = fn
+		}
+	}
+
+	if runOptions.namePolicy != nil {
+		metadata := map[string]string{"base": base, "format": format, "runId": runOptions.runID}
+		if s.scrollPercent >= 0 {
+			metadata["scrollPercent"] = strconv.Itoa(s.scrollPercent)
+		}
+		if s.frameSelector != "" {
+			metadata["frameSelector"] = s.frameSelector
+		}
+
+		p, err := runOptions.namePolicy(u, metadata)
+		if err != nil {
+			return "", fmt.Errorf("name policy failed: %w", err)
+		}
+		if base, err = relativePath(p); err != nil {
+			return "", err
+		}
 	}
 
-	logger.Printf("screenshot taker server %s is available", conf.Server.Host)
+	return fmt.Sprintf("%s%s%s.%s", base, runOptions.postfix, s.suffix(), format), nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// mask hides known-dynamic areas (dates, tickers, ads) from visual
+// comparisons. Match is a glob against the URL path, such as "/blog/*"; an
+// empty Match applies to every URL. Unlike domains, every matching mask
+// applies. Rects are "x,y,width,height" in pixels, the format -flakinessFile
+// suggests; Selectors are CSS selectors blacked out by the renderer.
+type mask struct {
+	Match     string   `yaml:"match"`
+	Rects     []string `yaml:"rects"`
+	Selectors []string `yaml:"selectors"`
+
+	rects []image.Rectangle
+}
+
+type maskSet []mask
+
+// loadMasks reads a YAML list of masks.
+func loadMasks(file string) (maskSet, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var masks maskSet
+	if err = yaml.Unmarshal(data, &masks); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for i := range masks {
+		m := &masks[i]
+		if _, err := path.Match(m.Match, "/"); err != nil {
+			return nil, fmt.Errorf("invalid match %q: %w", m.Match, err)
+		}
+		for _, r := range m.Rects {
+			rect, err := parseRect(r)
+			if err != nil {
+				return nil, err
+			}
+			m.rects = append(m.rects, rect)
+		}
+	}
+	return masks, nil
+}
+
+func parseRect(v string) (image.Rectangle, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("mask %q must be x,y,width,height", v)
+	}
+
+	var n [4]int
+	for i, p := range parts {
+		var err error
+		if n[i], err = strconv.Atoi(strings.TrimSpace(p)); err != nil || n[i] < 0 {
+			return image.Rectangle{}, fmt.Errorf("mask %q must be x,y,width,height", v)
+		}
+	}
+	return image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3]), nil
+}
+
+func (masks maskSet) matching(u string) []*mask {
+	p := u
+	if parsed, err := url.Parse(u); err == nil {
+		p = parsed.Path
+		if p == "" {
+			p = "/"
+		}
+	}
+
+	var list []*mask
+	for i := range masks {
+		if ok, _ := path.Match(masks[i].Match, p); ok || masks[i].Match == "" {
+			list = append(list, &masks[i])
+		}
+	}
+	return list
+}
+
+func (masks maskSet) hasSelectors() bool {
+	for _, m := range masks {
+		if len(m.Selectors) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// style returns the CSS that blacks out the selector masks of u.
+func (masks maskSet) style(u string) string {
+	var selectors []string
+	for _, m := range masks.matching(u) {
+		selectors = append(selectors, m.Selectors...)
+	}
+	if len(selectors) == 0 {
+		return ""
+	}
+	return strings.Join(selectors, ", ") + " { filter: brightness(0) !important; }"
+}
+
+// apply blacks out the rectangle masks of u in img.
+func (masks maskSet) apply(u string, img image.Image) image.Image {
+	var rects []image.Rectangle
+	for _, m := range masks.matching(u) {
+		rects = append(rects, m.rects...)
+	}
+	if len(rects) == 0 {
+		return img
+	}
+
+	b := img.Bounds()
+	masked := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(masked, masked.Bounds(), img, b.Min, draw.Src)
+	for _, r := range rects {
+		draw.Draw(masked, r.Intersect(masked.Bounds()), image.Black, image.Point{}, draw.Src)
+	}
+	return masked
+}
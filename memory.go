@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"io"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// uploadBufferSize is what the object stores buffer of an upload at most:
+// the part size of S3, the chunk size of GCS and the block size of Azure.
+// Everything else streams from the spooled image with small fixed buffers.
+const uploadBufferSize = 8 << 20
+
+// memoryBudget bounds the memory captures hold at once for decoded images
+// and upload buffers (-maxMemory). A nil budget is unlimited.
+type memoryBudget struct {
+	sem  *semaphore.Weighted
+	size int64
+}
+
+func newMemoryBudget(size int64) *memoryBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &memoryBudget{sem: semaphore.NewWeighted(size), size: size}
+}
+
+// reserve blocks until n bytes of the budget are free and returns the
+// function giving them back. Requests beyond the budget wait for all of it,
+// so an oversized image is still processed, alone. Callers hold a single
+// reservation at a time, which keeps them from deadlocking each other.
+func (m *memoryBudget) reserve(n int64) func() {
+	if m == nil || n <= 0 {
+		return func() {}
+	}
+	if n > m.size {
+		n = m.size
+	}
+	_ = m.sem.Acquire(ctx, n)
+	return func() { m.sem.Release(n) }
+}
+
+// decodedSize estimates the memory the image read from r takes once
+// decoded, from its dimensions; 0 when they can't be read.
+func decodedSize(r io.Reader) int64 {
+	conf, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0
+	}
+	return int64(conf.Width) * int64(conf.Height) * 4
+}
+
+// uploadBuffers returns the memory an upload to s buffers at most.
+func uploadBuffers(s storage) int64 {
+	switch v := s.(type) {
+	case *s3Storage, *gcsStorage, *azureStorage:
+		return uploadBufferSize
+	case *teeStorage:
+		var n int64
+		for _, target := range v.targets {
+			n += uploadBuffers(target)
+		}
+		return n
+	}
+	return 0
+}
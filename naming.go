@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"plugin"
+	"strings"
+)
+
+// namePolicy returns the output path of a capture, relative to the output
+// and without an extension. A plugin built with `go build -buildmode=plugin`
+// provides it by exporting
+//
+//	func OutputPath(url string, metadata map[string]string) (string, error)
+//
+// metadata holds the default name ("base"), "format", "runId" and, for
+// positioned or frame shots, "scrollPercent" and "frameSelector".
+type namePolicy func(u string, metadata map[string]string) (string, error)
+
+func loadNamePlugin(file string) (namePolicy, error) {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sym, err := p.Lookup("OutputPath")
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := sym.(func(string, map[string]string) (string, error))
+	if !ok {
+		return nil, fmt.Errorf("OutputPath must be a func(url string, metadata map[string]string) (string, error), got %T", sym)
+	}
+	return fn, nil
+}
+
+// relativePath cleans a path returned by a name policy and refuses anything
+// that would escape the output.
+func relativePath(p string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(p, "\\", "/"))
+	if cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("name policy returned invalid path %q", p)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/url"
+	"strings"
+)
+
+// trackingParams are the query parameters -stripTracking removes, besides
+// every utm_* one. They identify the visitor or campaign, not the page.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "gbraid": true, "wbraid": true, "msclkid": true,
+	"yclid": true, "igshid": true, "mc_cid": true, "mc_eid": true, "_ga": true, "_gl": true,
+}
+
+// urlDeduper canonicalizes input URLs and remembers them, so the same page
+// listed several times is captured once (-dedupURLs). It keeps a hash per
+// URL rather than the URL to bound the memory of big lists.
+type urlDeduper struct {
+	stripTracking bool
+	seen          map[[sha256.Size]byte]int
+}
+
+func newURLDeduper(stripTracking bool) *urlDeduper {
+	return &urlDeduper{stripTracking: stripTracking, seen: map[[sha256.Size]byte]int{}}
+}
+
+// admit returns the canonical form of u and whether it was admitted before,
+// then with the line it was first seen on.
+func (d *urlDeduper) admit(u string, line int) (string, int, bool) {
+	if d == nil {
+		return u, 0, false
+	}
+
+	u = canonicalURL(u, d.stripTracking)
+	key := sha256.Sum256([]byte(u))
+	if first, ok := d.seen[key]; ok {
+		return u, first, true
+	}
+	d.seen[key] = line
+	return u, 0, false
+}
+
+// canonicalURL lowercases the scheme and host of u, drops a default port,
+// gives an empty path a slash and, with stripTracking, removes tracking
+// parameters. URLs that don't parse are returned as they are.
+func canonicalURL(u string, stripTracking bool) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return u
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host, port := strings.ToLower(parsed.Hostname()), parsed.Port()
+	if (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	parsed.Host = host
+	if parsed.Path == "" && parsed.RawPath == "" {
+		parsed.Path = "/"
+	}
+
+	if stripTracking && parsed.RawQuery != "" {
+		query := parsed.Query()
+		removed := false
+		for k := range query {
+			if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+				query.Del(k)
+				removed = true
+			}
+		}
+		// the query is only re-encoded, which sorts it, when it changed
+		if removed {
+			parsed.RawQuery = query.Encode()
+		}
+	}
+	return parsed.String()
+}
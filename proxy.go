@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Proxy rotation policies.
+const (
+	rotateRoundRobin = "roundrobin"
+	rotateSticky     = "sticky"
+)
+
+// errNoProxies fails captures once every proxy of the pool has been dropped.
+var errNoProxies = errors.New("no live proxies left")
+
+// proxyPool hands out the proxies the renderer fetches pages through. A proxy
+// that fails maxFailures renders in a row is dropped from the pool.
+type proxyPool struct {
+	mu          sync.Mutex
+	policy      string
+	maxFailures int
+	live        []*proxyState
+	next        int
+	sticky      map[string]*proxyState
+}
+
+type proxyState struct {
+	address  string
+	failures int
+	dead     bool
+}
+
+// newProxyPool parses a comma-separated list of http://, https:// or
+// socks5:// proxy URLs. An empty list returns a nil pool.
+func newProxyPool(list, policy string, maxFailures int) (*proxyPool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	if policy != rotateRoundRobin && policy != rotateSticky {
+		return nil, fmt.Errorf("unknown rotation policy %q, want %s or %s", policy, rotateRoundRobin, rotateSticky)
+	}
+
+	p := &proxyPool{policy: policy, maxFailures: maxFailures, sticky: map[string]*proxyState{}}
+	for _, address := range strings.Split(list, ",") {
+		address = strings.TrimSpace(address)
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", address)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme in %q", address)
+		}
+		p.live = append(p.live, &proxyState{address: address})
+	}
+	return p, nil
+}
+
+// pick returns the proxy to render a page of host through.
+func (p *proxyPool) pick(host string) (*proxyState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.live) == 0 {
+		return nil, errNoProxies
+	}
+
+	if p.policy == rotateSticky {
+		if s := p.sticky[host]; s != nil && !s.dead {
+			return s, nil
+		}
+	}
+
+	s := p.live[p.next%len(p.live)]
+	p.next++
+	if p.policy == rotateSticky {
+		p.sticky[host] = s
+	}
+	return s, nil
+}
+
+// report records whether a render through s failed because of the proxy;
+// a render that didn't resets its failure count.
+func (p *proxyPool) report(s *proxyState, failed bool, logger *log.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !failed {
+		s.failures = 0
+		return
+	}
+
+	s.failures++
+	if s.dead || p.maxFailures <= 0 || s.failures < p.maxFailures {
+		return
+	}
+
+	s.dead = true
+	for i, l := range p.live {
+		if l == s {
+			p.live = append(p.live[:i], p.live[i+1:]...)
+			break
+		}
+	}
+	logger.Printf("dropping proxy %s after %d failed renders, %d left", displayProxy(s.address), s.failures, len(p.live))
+}
+
+// hasCredentials reports whether any proxy of the pool has a user or
+// password in its URL.
+func (p *proxyPool) hasCredentials() bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.live {
+		if u, err := url.Parse(s.address); err == nil && u.User != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// displayProxy strips credentials from a proxy URL for logs and results.
+func displayProxy(address string) string {
+	u, err := url.Parse(address)
+	if err != nil || u.User == nil {
+		return address
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// quickSource is the input of a quick capture, `capture <url> -o shot.png`:
+// the one URL, named after -o when it is given.
+type quickSource struct {
+	j    job
+	done bool
+}
+
+func newQuickSource(u, output string) (*quickSource, error) {
+	if !strings.Contains(u, "://") {
+		return nil, fmt.Errorf("%q is not a URL", u)
+	}
+	s := &quickSource{j: job{url: u, line: 1}}
+	if output != "" {
+		if err := s.j.options.setFileName(filepath.Base(output)); err != nil {
+			return nil, fmt.Errorf("-o: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *quickSource) next() (job, error) {
+	if s.done {
+		return job{}, io.EOF
+	}
+	s.done = true
+	return s.j, nil
+}
+
+// quickResult prints where the shots of a quick capture were saved, the
+// only output on stdout, or fails with why they weren't. With -stdout or
+// -dataURL the images are printed instead, and -copy puts them on the
+// clipboard.
+func quickResult(report *runReport) error {
+	var failures []string
+	for _, res := range report.Results {
+		switch res.Status {
+		case statusSaved:
+			if err := quickOutputs(res.StoragePath); err != nil {
+				return err
+			}
+		case statusFailed:
+			failures = append(failures, res.Error)
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func quickOutputs(path string) error {
+	mime := contentTypes[strings.TrimPrefix(filepath.Ext(path), ".")]
+	if *quickCopy {
+		if err := copyImage(path, mime); err != nil {
+			return fmt.Errorf("can't copy %s to the clipboard: %w", path, err)
+		}
+	}
+	if !*quickStdout && !*quickDataURL {
+		if *quickCopy && *quickOutput == "" {
+			return nil
+		}
+		fmt.Println(path)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if *quickDataURL {
+		fmt.Printf("data:%s;base64,%s\n", mime, base64.StdEncoding.EncodeToString(data))
+		return nil
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// copyImage puts the image at path on the system clipboard with the tool of
+// the platform: osascript on macOS, wl-copy on Wayland or else xclip, and
+// PowerShell on Windows.
+func copyImage(path, mime string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		class := "PNGf"
+		if mime == "image/jpeg" {
+			class = "JPEG"
+		}
+		// AppleScript strings have no \u escapes, only \ and " need one
+		quoted := `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(path) + `"`
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("set the clipboard to (read (POSIX file %s) as «class %s»)", quoted, class))
+	case "windows":
+		// -Command joins its arguments into the script, so the path goes
+		// in the environment
+		cmd = exec.Command("powershell", "-NoProfile", "-STA", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms, System.Drawing; [System.Windows.Forms.Clipboard]::SetImage([System.Drawing.Image]::FromFile($env:SCREENSHOTER_COPY_PATH))")
+		cmd.Env = append(os.Environ(), "SCREENSHOTER_COPY_PATH="+path)
+	default:
+		if _, err := exec.LookPath("wl-copy"); err == nil && os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.Command("wl-copy", "--type", mime)
+		} else {
+			cmd = exec.Command("xclip", "-selection", "clipboard", "-t", mime, "-i")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+	// xclip and wl-copy stay behind to serve the clipboard; output through
+	// a pipe would be waited for until they exit
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// withFlagServer replaces the servers of conf with the one -server names,
+// when it is set.
+func withFlagServer(conf *config, logger *log.Logger) *config {
+	if *serverURL == "" {
+		return conf
+	}
+
+	s, err := parseServerURL(*serverURL)
+	if err != nil {
+		logger.Panicf("invalid -server: %v", err)
+	}
+	conf.Server, conf.Servers = s, nil
+	return conf
+}
+
+// parseServerURL reads a -server like http://localhost:5601, with the paths
+// of the sample config.yaml unless it has one.
+func parseServerURL(s string) (serverConfig, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return serverConfig{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return serverConfig{}, fmt.Errorf("%q is not an http(s) URL", s)
+	}
+
+	port := 80
+	if u.Scheme == "https" {
+		port = 443
+	}
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return serverConfig{}, fmt.Errorf("invalid port %q", p)
+		}
+	}
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	conf := serverConfig{Host: u.Scheme + "://" + host, Port: port, PingPath: "api/ping", ActionPath: "api/screenshots"}
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		conf.ActionPath = p
+	}
+	return conf, nil
+}
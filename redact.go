@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// redactor hides URLs and file names from logs, webhooks and result indexes
+// when -redactLogs is set. Values are replaced by a short stable hash so the
+// same page can still be followed through a log; the report written with
+// -report keeps the full values and is the mapping back to them.
+type redactor struct {
+	enabled bool
+}
+
+func (r redactor) value(v string) string {
+	if !r.enabled || v == "" {
+		return v
+	}
+
+	sum := sha256.Sum256([]byte(v))
+	return "redacted:" + hex.EncodeToString(sum[:6])
+}
+
+// within redacts every occurrence of values (raw or query-escaped) in text,
+// which is how URLs end up inside error messages.
+func (r redactor) within(text string, values ...string) string {
+	if !r.enabled {
+		return text
+	}
+
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		text = strings.ReplaceAll(text, v, r.value(v))
+		text = strings.ReplaceAll(text, url.QueryEscape(v), r.value(v))
+	}
+	return text
+}
+
+// result returns a copy of res that is safe to send to external systems.
+func (r redactor) result(res *captureResult) *captureResult {
+	if !r.enabled {
+		return res
+	}
+
+	redacted := *res
+	redacted.URL = r.value(res.URL)
+	redacted.OriginalURL = r.value(res.OriginalURL)
+	redacted.FileName = r.value(res.FileName)
+	redacted.Base = r.value(res.Base)
+	redacted.StoragePath = r.value(res.StoragePath)
+	redacted.SignedURL = r.value(res.SignedURL)
+	if res.Render != nil {
+		render := *res.Render
+		render.FinalURL = r.value(render.FinalURL)
+		redacted.Render = &render
+	}
+	redacted.Error = r.within(res.Error, res.URL, res.OriginalURL, res.FileName, res.StoragePath)
+	if res.Diagnostics != nil {
+		// an error page or a Location header may well name the page
+		diagnostics := responseDiagnostics{Snippet: r.within(res.Diagnostics.Snippet, res.URL, res.OriginalURL, res.FileName)}
+		if len(res.Diagnostics.Headers) > 0 {
+			diagnostics.Headers = make(map[string]string, len(res.Diagnostics.Headers))
+		}
+		for k, v := range res.Diagnostics.Headers {
+			diagnostics.Headers[k] = r.within(v, res.URL, res.OriginalURL, res.FileName)
+		}
+		redacted.Diagnostics = &diagnostics
+	}
+
+	redacted.ExtraFiles = nil
+	for _, f := range res.ExtraFiles {
+		redacted.ExtraFiles = append(redacted.ExtraFiles, r.value(f))
+	}
+
+	redacted.Destinations = nil
+	for _, d := range res.Destinations {
+		d.Location = r.value(d.Location)
+		d.Error = r.within(d.Error, res.URL, res.FileName)
+		redacted.Destinations = append(redacted.Destinations, d)
+	}
+
+	redacted.Links = nil
+	for _, l := range res.Links {
+		redacted.Links = append(redacted.Links, r.value(l))
+	}
+
+	redacted.AssetErrors = nil
+	for _, a := range res.AssetErrors {
+		a.Error = r.within(a.Error, a.URL)
+		a.URL = r.value(a.URL)
+		redacted.AssetErrors = append(redacted.AssetErrors, a)
+	}
+	return &redacted
+}
+
+func (r redactor) comparison(c *comparison) *comparison {
+	if !r.enabled {
+		return c
+	}
+
+	redacted := *c
+	redacted.Path = r.value(c.Path)
+	redacted.DiffFile = r.value(c.DiffFile)
+	redacted.Error = r.within(c.Error, c.Path, c.DiffFile)
+	return &redacted
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultHeaders names the response headers a server reports render details
+// in, set with resultHeaders in config.yaml. Empty names keep the defaults;
+// "-" ignores the header.
+type resultHeaders struct {
+	RenderTime string `yaml:"renderTime"`
+	FinalURL   string `yaml:"finalUrl"`
+	PageStatus string `yaml:"pageStatus"`
+	// Other headers are copied to the results as they are.
+	Other []string `yaml:"other"`
+}
+
+// renderInfo is what the server reported about a render in its response
+// headers.
+type renderInfo struct {
+	RenderTimeMs int64 `json:"renderTimeMs,omitempty"`
+	// FinalURL is the page URL after redirects.
+	FinalURL string `json:"finalUrl,omitempty"`
+	// PageStatus is the status the page answered the renderer with.
+	PageStatus int               `json:"pageStatus,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+func headerName(name, fallback string) string {
+	switch name {
+	case "":
+		return fallback
+	case "-":
+		return ""
+	}
+	return name
+}
+
+// parse reads the render details from the headers of a response, given by
+// get; it returns nil when the server reported none.
+func (h resultHeaders) parse(get func(string) string) *renderInfo {
+	value := func(name, fallback string) string {
+		if name = headerName(name, fallback); name == "" {
+			return ""
+		}
+		return strings.TrimSpace(get(name))
+	}
+
+	info := &renderInfo{FinalURL: value(h.FinalURL, "X-Final-Url")}
+	if v := value(h.RenderTime, "X-Render-Time"); v != "" {
+		info.RenderTimeMs = parseRenderTime(v)
+	}
+	if v := value(h.PageStatus, "X-Page-Status"); v != "" {
+		info.PageStatus, _ = strconv.Atoi(v)
+	}
+	for _, name := range h.Other {
+		if v := strings.TrimSpace(get(name)); v != "" {
+			if info.Headers == nil {
+				info.Headers = map[string]string{}
+			}
+			info.Headers[name] = v
+		}
+	}
+
+	if info.RenderTimeMs == 0 && info.FinalURL == "" && info.PageStatus == 0 && len(info.Headers) == 0 {
+		return nil
+	}
+	return info
+}
+
+// parseRenderTime accepts a duration such as 1.2s or 830ms, or a bare
+// number of milliseconds.
+func parseRenderTime(v string) int64 {
+	if d, err := time.ParseDuration(v); err == nil {
+		return d.Milliseconds()
+	}
+	if ms, err := strconv.ParseFloat(v, 64); err == nil {
+		return int64(ms)
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// replaySource is the input of a replay: the URLs of a run report, in the
+// order the run started them, each named after the base its shots were
+// saved under. Lines that were invalid fail again. The per-URL overrides of
+// a tabular or JSON Lines input other than the name, format and tags aren't
+// in the report and aren't replayed.
+type replaySource struct {
+	runID string
+	jobs  []replayJob
+}
+
+type replayJob struct {
+	j       job
+	err     error
+	started time.Time
+}
+
+// newReplaySource reads the jobs of r; renderFormat is the format the run
+// rendered in, so that only the names of other formats pin theirs.
+func newReplaySource(r *runReport, renderFormat string) *replaySource {
+	type key struct {
+		line int
+		url  string
+	}
+	s := &replaySource{runID: r.RunID}
+	index := map[key]int{}
+	for _, res := range r.Results {
+		u := res.URL
+		if res.OriginalURL != "" {
+			u = res.OriginalURL
+		}
+		k := key{res.Line, u}
+		i, ok := index[k]
+		if !ok {
+			i = len(s.jobs)
+			index[k] = i
+			rj := replayJob{j: job{url: u, line: res.Line}, started: res.StartedAt}
+			rj.j.options.tags = res.Tags
+			// only lines the input couldn't read fail without a capture
+			if res.Status == statusFailed && res.CaptureID == "" {
+				rj.err = errors.New(res.Error)
+			}
+			s.jobs = append(s.jobs, rj)
+		}
+
+		rj := &s.jobs[i]
+		if res.StartedAt.Before(rj.started) {
+			rj.started = res.StartedAt
+		}
+		if res.Base != "" && rj.j.options.fileName == "" {
+			rj.j.options.fileName = res.Base
+			if f := rowFormat(extension(res.FileName)); res.Fallback == "" && f != renderFormat {
+				rj.j.options.format = f
+			}
+		}
+	}
+
+	sort.SliceStable(s.jobs, func(a, b int) bool { return s.jobs[a].started.Before(s.jobs[b].started) })
+	return s
+}
+
+func (s *replaySource) next() (job, error) {
+	if len(s.jobs) == 0 {
+		return job{}, io.EOF
+	}
+	next := s.jobs[0]
+	s.jobs = s.jobs[1:]
+	return next.j, next.err
+}
+
+// replaying is set for the run of a replay, which opens no tickets.
+var replaying bool
+
+// runReplay implements `screenshoter replay [flags] report.json`: it parses
+// the command line the report recorded, then swaps the input for the URLs
+// of the report and sends what the run wrote to a new output directory.
+// Webhooks, -review, tickets, -elasticURL and -flakinessFile are left out.
+func runReplay(c *command, args []string) error {
+	fs := c.flagSet(false)
+	keyFile := fs.String("key", "", "File with the hex-encoded 32-byte key, for reports written with -encrypt")
+	dir := fs.String("outputDir", "", "Directory the replay is saved to, with its report.json, -stateFile and -failedFile (default replay-<run id>)")
+	force := fs.Bool("force", false, "Replay into an existing -outputDir, and even though config.yaml differs from the run's")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := readReport(fs.Arg(0), *keyFile)
+	if err != nil {
+		return err
+	}
+	if len(r.Command) == 0 {
+		return fmt.Errorf("%s doesn't record the command line of run %s, it was written by an older screenshoter", fs.Arg(0), r.RunID)
+	}
+	modes := map[string]captureMode{"capture": modeCapture, "diff": modeDiff}
+	mode, ok := modes[r.Command[0]]
+	if !ok {
+		return fmt.Errorf("can't replay a %s run", r.Command[0])
+	}
+
+	fingerprint, err := configFingerprint("config.yaml")
+	if err != nil {
+		return err
+	}
+	if fingerprint != r.ConfigFingerprint {
+		if !*force {
+			return fmt.Errorf("config.yaml differs from the one run %s used; the replay would render with other settings (-force to replay anyway)", r.RunID)
+		}
+		fmt.Fprintln(os.Stderr, "warning: config.yaml differs from the one the run used")
+	}
+
+	if *dir == "" {
+		*dir = "replay-" + r.RunID
+	}
+	if _, err := os.Stat(*dir); err == nil && !*force {
+		return fmt.Errorf("%s already exists (-force to replay into it)", *dir)
+	}
+
+	if err = parseCaptureFlags(findCommand(r.Command[0]), mode, r.Command[1:]); err != nil {
+		return fmt.Errorf("can't replay the command line of run %s: %w", r.RunID, err)
+	}
+	if mode == modeDiff && *bootstrap {
+		return fmt.Errorf("run %s wrote the baseline in %s, replaying it would overwrite the baseline", r.RunID, *baselineDir)
+	}
+	imf, err := parseImageFormats(*format)
+	if err != nil {
+		return fmt.Errorf("invalid -imageFormat of run %s: %w", r.RunID, err)
+	}
+
+	// the URLs are the report's already picked from the input, and the
+	// files of the run are written to the replay's directory
+	*filePath, *sitemap, *feed, *shardOf, *inputCacheAt = fs.Arg(0), "", "", "", ""
+	*quickURL, *quickOutput = "", ""
+	*quickStdout, *quickDataURL, *quickCopy = false, false, false
+	*offset, *limit, *sample = 0, 0, 0
+	*crawl, *follow, *resume, *estimate = false, false, false, false
+	*maxCost = 0
+	includeURLs, excludeURLs, outputs = nil, nil, nil
+	// nor does it report to what the run reported to, or add to its
+	// flakiness history
+	*doneWebhook, *slowWebhook, *review, *elasticURL, *flakinessFile = "", "", "", "", ""
+	replaying = true
+	*outputPath = *dir
+	*reportPath = filepath.Join(*dir, "report.json")
+	if *stateFile != "" {
+		*stateFile = filepath.Join(*dir, filepath.Base(*stateFile))
+	}
+	if *failedPath != "" {
+		*failedPath = filepath.Join(*dir, filepath.Base(*failedPath))
+	}
+	if err = os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	source := newReplaySource(r, imf.format)
+	fmt.Fprintf(os.Stderr, "replaying %d URLs of run %s into %s\n", len(source.jobs), r.RunID, *dir)
+	return runCapture(mode, source)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runReport collects every capture result of a run and is written out as
+// JSON once the batch completes.
+type runReport struct {
+	mu         sync.Mutex
+	RunID      string    `json:"runId"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Total      int       `json:"total"`
+	Saved      int       `json:"saved"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped,omitempty"`
+	// Differences counts the -compareEnvironments pairs that differ.
+	Differences int `json:"differences,omitempty"`
+	// SizeAnomalies counts the results flagged with a SizeAnomaly.
+	SizeAnomalies int `json:"sizeAnomalies,omitempty"`
+	// SlowCaptures counts the results flagged Slow.
+	SlowCaptures int    `json:"slowCaptures,omitempty"`
+	Usage        *usage `json:"usage,omitempty"`
+	// Cost is set with -estimate, in the currency of the pricing section.
+	Cost        float64          `json:"cost,omitempty"`
+	Sizes       []domainSizes    `json:"sizes,omitempty"`
+	Results     []*captureResult `json:"results"`
+	Comparisons []*comparison    `json:"comparisons,omitempty"`
+	// Command is the command line of the run, the command first, with
+	// secrets masked, and ConfigFingerprint the config.yaml it ran with, for
+	// replay. Redaction leaves out the command line, which names the input.
+	Command           []string `json:"command,omitempty"`
+	ConfigFingerprint string   `json:"configFingerprint,omitempty"`
+	// ReplayOf is the run a replay re-ran.
+	ReplayOf string `json:"replayOf,omitempty"`
+}
+
+func newRunReport(runID string) *runReport {
+	return &runReport{RunID: runID, StartedAt: time.Now()}
+}
+
+func (r *runReport) record(res *captureResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Total++
+	switch res.Status {
+	case statusSaved:
+		r.Saved++
+	case statusSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	if res.Slow {
+		r.SlowCaptures++
+	}
+	r.Results = append(r.Results, res)
+	return nil
+}
+
+func (r *runReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now()
+}
+
+func (r *runReport) marshal() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return marshalIndented(r)
+}
+
+// marshalFor renders the report for an external receiver such as a webhook,
+// with URLs and file names redacted when -redactLogs is set.
+func (r *runReport) marshalFor(red redactor) ([]byte, error) {
+	if !red.enabled {
+		return r.marshal()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := &runReport{
+		RunID:       r.RunID,
+		StartedAt:   r.StartedAt,
+		FinishedAt:  r.FinishedAt,
+		Total:       r.Total,
+		Saved:       r.Saved,
+		Failed:      r.Failed,
+		Skipped:     r.Skipped,
+		Differences: r.Differences,
+		Usage:       r.Usage,
+		Cost:        r.Cost,
+		ReplayOf:    r.ReplayOf,
+
+		ConfigFingerprint: r.ConfigFingerprint,
+
+		SizeAnomalies: r.SizeAnomalies,
+		SlowCaptures:  r.SlowCaptures,
+	}
+	for _, s := range r.Sizes {
+		s.Domain = red.value(s.Domain)
+		copied.Sizes = append(copied.Sizes, s)
+	}
+	for _, res := range r.Results {
+		copied.Results = append(copied.Results, red.result(res))
+	}
+	for _, c := range r.Comparisons {
+		copied.Comparisons = append(copied.Comparisons, red.comparison(c))
+	}
+	return marshalIndented(copied)
+}
+
+// marshalIndented is json.MarshalIndent without HTML escaping, so URLs in
+// the report stay readable.
+func marshalIndented(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFile saves the full report. With an encryption key it is written
+// encrypted to filePath+".enc", since it maps redacted values back to URLs.
+func (r *runReport) writeFile(filePath string, aead cipher.AEAD) error {
+	data, err := r.marshal()
+	if err != nil {
+		return err
+	}
+	if aead == nil {
+		return os.WriteFile(filePath, data, 0644)
+	}
+
+	f, err := os.OpenFile(filePath+encryptedExt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = encryptStream(f, bytes.NewReader(data), aead); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runReportSummary implements `screenshoter report [-key file] report.json`.
+func runReportSummary(c *command, args []string) error {
+	fs := c.flagSet(false)
+	keyFile := fs.String("key", "", "File with the hex-encoded 32-byte key, for reports written with -encrypt")
+	top := fs.Int("top", 10, "Number of most frequent errors listed (0 = all)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := readReport(fs.Arg(0), *keyFile)
+	if err != nil {
+		return err
+	}
+	r.summarize(os.Stdout, *top)
+	return nil
+}
+
+// readReport reads the JSON report of a run, decrypting it with the key in
+// keyFile when one is given.
+func readReport(path, keyFile string) (*runReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if keyFile != "" {
+		aead, err := readKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = decryptStream(&buf, bytes.NewReader(data), aead); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data = buf.Bytes()
+	}
+
+	var r runReport
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// summarize prints the counts of the report and its most frequent errors.
+func (r *runReport) summarize(w io.Writer, top int) {
+	fmt.Fprintf(w, "run %s, started %s", r.RunID, r.StartedAt.Format(time.RFC3339))
+	if !r.FinishedAt.IsZero() {
+		fmt.Fprintf(w, ", took %s", r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	}
+	fmt.Fprintf(w, "\n%d captures: %d saved, %d failed, %d skipped\n", r.Total, r.Saved, r.Failed, r.Skipped)
+	if len(r.Comparisons) > 0 {
+		fmt.Fprintf(w, "%d of %d comparisons differ\n", r.Differences, len(r.Comparisons))
+	}
+	if r.SizeAnomalies > 0 {
+		fmt.Fprintf(w, "%d captures have suspicious sizes:\n", r.SizeAnomalies)
+		for _, res := range r.Results {
+			if res.SizeAnomaly != "" {
+				fmt.Fprintf(w, "  %s: %d bytes, %s\n", res.URL, res.Bytes, res.SizeAnomaly)
+			}
+		}
+	}
+	if r.SlowCaptures > 0 {
+		fmt.Fprintf(w, "%d captures were slow:\n", r.SlowCaptures)
+		for _, res := range r.Results {
+			if res.Slow {
+				fmt.Fprintf(w, "  %s: %s\n", res.URL, time.Duration(res.DurationMs)*time.Millisecond)
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	for _, res := range r.Results {
+		if res.Error != "" {
+			counts[res.Error]++
+		}
+	}
+	errs := make([]string, 0, len(counts))
+	for e := range counts {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if counts[errs[i]] != counts[errs[j]] {
+			return counts[errs[i]] > counts[errs[j]]
+		}
+		return errs[i] < errs[j]
+	})
+	if top > 0 && len(errs) > top {
+		errs = errs[:top]
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "\nmost frequent errors:\n")
+	}
+	for _, e := range errs {
+		fmt.Fprintf(w, "%6d  %s\n", counts[e], e)
+	}
+}
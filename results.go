@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"log"
+	"time"
+)
+
+const (
+	statusSaved  = "saved"
+	statusFailed = "failed"
+	// statusSkipped marks URLs that were deliberately not captured.
+	statusSkipped = "skipped"
+)
+
+// errOversized fails captures whose image is larger than -maxImageSize.
+var errOversized = errors.New("image exceeds -maxImageSize")
+
+// errIncomplete fails renders answered with success but an empty or
+// truncated image; they are retried like server errors.
+var errIncomplete = errors.New("incomplete image")
+
+// Correlation headers sent with every render request so server-side logs can
+// be matched to a run and to a single capture.
+const (
+	runIDHeader     = "X-Screenshoter-Run-Id"
+	captureIDHeader = "X-Screenshoter-Capture-Id"
+)
+
+// captureResult describes the outcome of a single URL and is handed to every
+// configured result sink once the capture finishes.
+type captureResult struct {
+	CaptureID   string `json:"captureId,omitempty"`
+	URL         string `json:"url"`
+	OriginalURL string `json:"originalUrl,omitempty"`
+	// ScrollPercent is set for shots taken with -capturePositions.
+	ScrollPercent *int `json:"scrollPercent,omitempty"`
+	// FrameSelector is set for shots taken with -frameSelector.
+	FrameSelector string `json:"frameSelector,omitempty"`
+	// Environment is set with -compareEnvironments.
+	Environment string `json:"environment,omitempty"`
+	// Tags are given by the URL's line of a JSON Lines input.
+	Tags        []string  `json:"tags,omitempty"`
+	Line        int       `json:"line,omitempty"`
+	FileName    string    `json:"fileName"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StatusCode  int       `json:"statusCode,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	SHA256      string    `json:"sha256,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	DurationMs  int64     `json:"durationMs"`
+	StoragePath string    `json:"storagePath,omitempty"`
+	ExtraFiles  []string  `json:"extraFiles,omitempty"`
+	// SignedURL is set with -signURLs.
+	SignedURL string `json:"signedUrl,omitempty"`
+	// Destinations is only set when writing to several outputs at once.
+	Destinations []destinationResult `json:"destinations,omitempty"`
+	Coalesced    bool                `json:"coalesced,omitempty"`
+	Fallback     string              `json:"fallback,omitempty"`
+	Proxy        string              `json:"proxy,omitempty"`
+	// Server is set when config.yaml lists several servers.
+	Server    string `json:"server,omitempty"`
+	Oversized bool   `json:"oversized,omitempty"`
+	// Slow is set for captures longer than -slowThreshold.
+	Slow bool `json:"slow,omitempty"`
+	// Base is what FileName was made of, before -postfix, the shot suffix
+	// and the extension; replay names the shot after it.
+	Base string `json:"base,omitempty"`
+	// Render holds what the server reported about the render in its
+	// response headers.
+	Render *renderInfo `json:"render,omitempty"`
+	// Diagnostics is set for renders failed with an incomplete image.
+	Diagnostics *responseDiagnostics `json:"diagnostics,omitempty"`
+	// SizeAnomaly is set in the report for images far smaller or larger
+	// than the others of their domain, see -sizeOutlierFactor.
+	SizeAnomaly string `json:"sizeAnomaly,omitempty"`
+	// Links and AssetErrors are set with -checkLinks.
+	Links       []string     `json:"links,omitempty"`
+	AssetErrors []assetError `json:"assetErrors,omitempty"`
+	// Flaky is set with -flakinessFile for shots that changed in each of the
+	// last -flakyRuns runs; FlakyRegions are the areas that always changed,
+	// as x,y,width,height.
+	Flaky        bool     `json:"flaky,omitempty"`
+	FlakyRegions []string `json:"flakyRegions,omitempty"`
+
+	// image is the decoded screenshot, kept until its environments are
+	// compared.
+	image image.Image
+}
+
+// resultSink receives finished capture results.
+type resultSink interface {
+	record(res *captureResult) error
+}
+
+func recordResult(runOptions *runOptions, res *captureResult, logger *log.Logger) {
+	res.DurationMs = time.Since(res.StartedAt).Milliseconds()
+	for _, sink := range runOptions.sinks {
+		if err := sink.record(res); err != nil {
+			logger.Printf("failed to record result for %s: %v", runOptions.value(res.URL), err)
+		}
+	}
+}
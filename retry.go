@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// retryable reports whether a failed render is worth repeating as is:
+// network errors, rate limiting, server errors and incomplete images usually
+// are, anything the server rejected or that exceeded a limit is not.
+func retryable(out *rendered, err error) bool {
+	if err == nil || errors.Is(err, errOversized) || errors.Is(err, errNoProxies) {
+		return false
+	}
+	if out == nil || out.statusCode == 0 || errors.Is(err, errIncomplete) {
+		return true
+	}
+	return out.statusCode == http.StatusTooManyRequests || out.statusCode >= 500
+}
+
+// backoff returns the wait before retry n (starting at 1): base doubled for
+// every previous retry, with jitter so parallel captures don't retry in step.
+func backoff(base time.Duration, n int) time.Duration {
+	d := base << (n - 1)
+	if d <= 0 || d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// renderWithRetries renders like render but repeats transient failures up to
+// -retries times, spending from the run's retry budget.
+func renderWithRetries(ctx context.Context, runOptions *runOptions, u, fileName, captureID string, opts captureOptions, logger *log.Logger) (*rendered, error) {
+	for n := 1; ; n++ {
+		start := time.Now()
+		out, err := renderBounded(ctx, runOptions, u, fileName, captureID, opts, logger)
+		runOptions.adaptive.observe(time.Since(start), retryable(out, err))
+		if n > runOptions.retries || !retryable(out, err) {
+			return out, err
+		}
+		if !runOptions.guard.spendRetry() {
+			logger.Printf("not retrying %s (capture %s): retry budget of %d exhausted", runOptions.value(u), captureID, runOptions.guard.budget)
+			return out, err
+		}
+
+		wait := backoff(runOptions.retryBackoff, n)
+		logger.Printf("retrying %s (capture %s) in %s, attempt %d of %d: %s", runOptions.value(u), captureID, wait.Round(time.Millisecond), n+1, runOptions.retries+1, runOptions.within(err.Error(), u, fileName))
+		time.Sleep(wait)
+		if err := runOptions.throttle(ctx, u); err != nil {
+			return out, err
+		}
+	}
+}
+
+// renderThroughProxy renders through the next proxy of the pool, if there is
+// one, and reports back how the proxy did. Retries pick a proxy afresh.
+func renderThroughProxy(ctx context.Context, runOptions *runOptions, u, fileName, captureID string, opts captureOptions, logger *log.Logger) (*rendered, error) {
+	if runOptions.proxies == nil {
+		return render(ctx, runOptions, u, fileName, captureID, opts)
+	}
+
+	proxy, err := runOptions.proxies.pick(hostOf(u))
+	if err != nil {
+		return nil, err
+	}
+
+	opts.proxy = proxy.address
+	out, err := render(ctx, runOptions, u, fileName, captureID, opts)
+	if out != nil {
+		out.proxy = displayProxy(proxy.address)
+	}
+	// the proxy is judged by what the page answered through it; the status
+	// of the renderer itself says nothing about the proxy
+	switch {
+	case out == nil || out.statusCode == 0:
+		if ctx.Err() == nil {
+			runOptions.proxies.report(proxy, true, logger)
+		}
+	case out.info != nil && out.info.PageStatus > 0:
+		status := out.info.PageStatus
+		runOptions.proxies.report(proxy, status == http.StatusTooManyRequests || status >= 500, logger)
+	case err == nil:
+		runOptions.proxies.report(proxy, false, logger)
+	}
+	return out, err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code review integrations selected with -review.
+const (
+	reviewGitHub = "github"
+	reviewGitLab = "gitlab"
+)
+
+// statusContext names the commit status so re-runs replace it.
+const statusContext = "screenshoter/visual"
+
+// reviewTarget publishes the outcome of a run to a code review system: a
+// commit status on the reviewed commit and a comment on the pull or merge
+// request, when there is one.
+type reviewTarget interface {
+	setStatus(success bool, description string) error
+	comment(body string) error
+}
+
+// newReviewTarget configures kind from the CI environment, with -reviewCommit
+// and -reviewRequest taking precedence. The token is read from GITHUB_TOKEN
+// or GITLAB_TOKEN.
+func newReviewTarget(kind, commit string, request int) (reviewTarget, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch kind {
+	case reviewGitHub:
+		r := &githubReview{
+			client: client,
+			api:    envOr("GITHUB_API_URL", "https://api.github.com"),
+			repo:   os.Getenv("GITHUB_REPOSITORY"),
+			token:  os.Getenv("GITHUB_TOKEN"),
+			sha:    firstNonEmpty(commit, os.Getenv("GITHUB_SHA")),
+			pr:     request,
+		}
+		if id := os.Getenv("GITHUB_RUN_ID"); id != "" {
+			r.runURL = fmt.Sprintf("%s/%s/actions/runs/%s", envOr("GITHUB_SERVER_URL", "https://github.com"), r.repo, id)
+		}
+		if r.pr == 0 {
+			r.pr = githubPullRequest()
+		}
+		if r.repo == "" || r.token == "" || r.sha == "" {
+			return nil, fmt.Errorf("-review github needs GITHUB_REPOSITORY, GITHUB_TOKEN and a commit (GITHUB_SHA or -reviewCommit)")
+		}
+		return r, nil
+	case reviewGitLab:
+		r := &gitlabReview{
+			client:  client,
+			api:     envOr("CI_API_V4_URL", "https://gitlab.com/api/v4"),
+			project: os.Getenv("CI_PROJECT_ID"),
+			token:   os.Getenv("GITLAB_TOKEN"),
+			sha:     firstNonEmpty(commit, os.Getenv("CI_COMMIT_SHA")),
+			mr:      request,
+			runURL:  os.Getenv("CI_JOB_URL"),
+		}
+		if r.mr == 0 {
+			r.mr, _ = strconv.Atoi(os.Getenv("CI_MERGE_REQUEST_IID"))
+		}
+		if r.project == "" || r.token == "" || r.sha == "" {
+			return nil, fmt.Errorf("-review gitlab needs CI_PROJECT_ID, GITLAB_TOKEN and a commit (CI_COMMIT_SHA or -reviewCommit)")
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown -review %q, want %s or %s", kind, reviewGitHub, reviewGitLab)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// githubPullRequest reads the pull request number from the event GitHub
+// Actions triggered the run with.
+func githubPullRequest() int {
+	data, err := os.ReadFile(os.Getenv("GITHUB_EVENT_PATH"))
+	if err != nil {
+		return 0
+	}
+	var event struct {
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	_ = json.Unmarshal(data, &event)
+	return event.PullRequest.Number
+}
+
+// sendJSON posts body to target and fails on any non-2xx answer.
+func sendJSON(client *http.Client, target string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return sendRequest(client, req, nil)
+}
+
+type githubReview struct {
+	client *http.Client
+	api    string
+	repo   string
+	token  string
+	sha    string
+	pr     int
+	runURL string
+}
+
+func (r *githubReview) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + r.token, "Accept": "application/vnd.github+json"}
+}
+
+func (r *githubReview) setStatus(success bool, description string) error {
+	state := "failure"
+	if success {
+		state = "success"
+	}
+	body := map[string]string{"state": state, "description": description, "context": statusContext}
+	if r.runURL != "" {
+		body["target_url"] = r.runURL
+	}
+	return sendJSON(r.client, fmt.Sprintf("%s/repos/%s/statuses/%s", r.api, r.repo, r.sha), r.headers(), body)
+}
+
+func (r *githubReview) comment(body string) error {
+	if r.pr == 0 {
+		return nil
+	}
+	return sendJSON(r.client, fmt.Sprintf("%s/repos/%s/issues/%d/comments", r.api, r.repo, r.pr), r.headers(), map[string]string{"body": body})
+}
+
+type gitlabReview struct {
+	client  *http.Client
+	api     string
+	project string
+	token   string
+	sha     string
+	mr      int
+	runURL  string
+}
+
+func (r *gitlabReview) headers() map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": r.token}
+}
+
+func (r *gitlabReview) setStatus(success bool, description string) error {
+	state := "failed"
+	if success {
+		state = "success"
+	}
+	body := map[string]string{"state": state, "description": description, "name": statusContext}
+	if r.runURL != "" {
+		body["target_url"] = r.runURL
+	}
+	return sendJSON(r.client, fmt.Sprintf("%s/projects/%s/statuses/%s", r.api, url.PathEscape(r.project), r.sha), r.headers(), body)
+}
+
+func (r *gitlabReview) comment(body string) error {
+	if r.mr == 0 {
+		return nil
+	}
+	return sendJSON(r.client, fmt.Sprintf("%s/projects/%s/merge_requests/%d/notes", r.api, url.PathEscape(r.project), r.mr), r.headers(), map[string]string{"body": body})
+}
+
+// reviewSummary is the one-line outcome of a run used as the status
+// description; a run passes when nothing failed and nothing changed.
+func reviewSummary(r *runReport) (bool, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	success := r.Failed == 0 && r.Differences == 0
+	summary := fmt.Sprintf("%d of %d shots changed", r.Differences, len(r.Comparisons))
+	if len(r.Comparisons) == 0 {
+		summary = fmt.Sprintf("%d saved", r.Saved)
+	}
+	if r.Failed > 0 {
+		summary += fmt.Sprintf(", %d failed", r.Failed)
+	}
+	return success, summary
+}
+
+// reviewComment renders the visual changes as Markdown: a table of the top
+// changed pages, diff images inlined when imageURL tells where the output is
+// published. Redacted runs get no images, as their names would give the
+// pages away.
+func reviewComment(r *runReport, red redactor, top int, imageURL string) string {
+	r.mu.Lock()
+	var changed []*comparison
+	for _, c := range r.Comparisons {
+		if c.Differs {
+			changed = append(changed, red.comparison(c))
+		}
+	}
+	r.mu.Unlock()
+
+	sort.SliceStable(changed, func(i, j int) bool { return changed[i].DiffPercent > changed[j].DiffPercent })
+
+	_, summary := reviewSummary(r)
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Visual changes\n\n%s.\n", summary)
+	if len(changed) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n| Page | Compared | Changed | Diff |\n|---|---|---|---|\n")
+	for i, c := range changed {
+		if top > 0 && i == top {
+			fmt.Fprintf(&b, "\n…and %d more.\n", len(changed)-top)
+			break
+		}
+		thumbnail := "–"
+		if imageURL != "" && c.diffName != "" && !red.enabled {
+			thumbnail = fmt.Sprintf(`<img src="%s" width="240">`, strings.TrimSuffix(imageURL, "/")+"/"+c.diffName)
+		}
+		fmt.Fprintf(&b, "| %s%s | %s → %s | %.2f%% | %s |\n", markdownCell(c.Path), markdownCell(c.Shot), c.A, c.B, c.DiffPercent, thumbnail)
+	}
+	return b.String()
+}
+
+func markdownCell(v string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(v)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// rewriteConfig is the rewrite section of config.yaml. Rules are applied in
+// the order: replace, forceHttps, removeParams, addParams, stripFragment.
+type rewriteConfig struct {
+	Replace []struct {
+		Find string `yaml:"find"`
+		With string `yaml:"with"`
+	} `yaml:"replace"`
+	ForceHTTPS    bool              `yaml:"forceHttps"`
+	RemoveParams  []string          `yaml:"removeParams"`
+	AddParams     map[string]string `yaml:"addParams"`
+	StripFragment bool              `yaml:"stripFragment"`
+}
+
+type replaceRule struct {
+	find *regexp.Regexp
+	with string
+}
+
+// rewriter fixes up input URLs before they are captured.
+type rewriter struct {
+	conf     rewriteConfig
+	replaces []replaceRule
+}
+
+func newRewriter(conf rewriteConfig) (*rewriter, error) {
+	r := &rewriter{conf: conf}
+	for _, rule := range conf.Replace {
+		re, err := regexp.Compile(rule.Find)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern %q: %w", rule.Find, err)
+		}
+		r.replaces = append(r.replaces, replaceRule{re, rule.With})
+	}
+	for _, p := range conf.RemoveParams {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid removeParams pattern %q: %w", p, err)
+		}
+	}
+	return r, nil
+}
+
+func (r *rewriter) enabled() bool {
+	c := r.conf
+	return len(r.replaces) > 0 || c.ForceHTTPS || len(c.RemoveParams) > 0 || len(c.AddParams) > 0 || c.StripFragment
+}
+
+func (r *rewriter) rewrite(u string) (string, error) {
+	for _, rule := range r.replaces {
+		u = rule.find.ReplaceAllString(u, rule.with)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", fmt.Errorf("rewritten url is invalid: %w", err)
+	}
+
+	if r.conf.ForceHTTPS && parsed.Scheme == "http" {
+		parsed.Scheme = "https"
+	}
+
+	if len(r.conf.RemoveParams) > 0 || len(r.conf.AddParams) > 0 {
+		query := parsed.Query()
+		for name := range query {
+			for _, pattern := range r.conf.RemoveParams {
+				if ok, _ := path.Match(pattern, name); ok {
+					query.Del(name)
+				}
+			}
+		}
+
+		names := make([]string, 0, len(r.conf.AddParams))
+		for name := range r.conf.AddParams {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			query.Set(name, r.conf.AddParams[name])
+		}
+		parsed.RawQuery = query.Encode()
+	}
+
+	if r.conf.StripFragment {
+		parsed.Fragment = ""
+		parsed.RawFragment = ""
+	}
+
+	return parsed.String(), nil
+}
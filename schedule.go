@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// What to do with captures that come up outside the allowed windows.
+const (
+	outsideWait = "wait"
+	outsideSkip = "skip"
+)
+
+// scheduleConfig restricts when captures may run, e.g. to honour agreements
+// with site owners. Windows are local "HH:MM-HH:MM" ranges and may wrap past
+// midnight; blackouts are "YYYY-MM-DD" dates on which nothing is captured.
+type scheduleConfig struct {
+	Windows       []string `yaml:"windows"`
+	Blackouts     []string `yaml:"blackouts"`
+	OutsideWindow string   `yaml:"outsideWindow"`
+}
+
+type clockRange struct {
+	from, to time.Duration
+}
+
+func (c clockRange) contains(d time.Duration) bool {
+	if c.from <= c.to {
+		return d >= c.from && d < c.to
+	}
+	return d >= c.from || d < c.to
+}
+
+// schedule is the parsed scheduleConfig; a nil schedule allows everything.
+type schedule struct {
+	windows   []clockRange
+	blackouts map[string]bool
+	skip      bool
+}
+
+func newSchedule(conf scheduleConfig) (*schedule, error) {
+	if len(conf.Windows) == 0 && len(conf.Blackouts) == 0 {
+		return nil, nil
+	}
+
+	s := &schedule{blackouts: map[string]bool{}}
+	switch conf.OutsideWindow {
+	case "", outsideWait:
+	case outsideSkip:
+		s.skip = true
+	default:
+		return nil, fmt.Errorf("outsideWindow must be %s or %s, got %q", outsideWait, outsideSkip, conf.OutsideWindow)
+	}
+
+	for _, w := range conf.Windows {
+		from, to, ok := strings.Cut(w, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q must look like 01:00-06:00", w)
+		}
+		var r clockRange
+		var err error
+		if r.from, err = parseClock(from); err == nil {
+			r.to, err = parseClock(to)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", w, err)
+		}
+		s.windows = append(s.windows, r)
+	}
+
+	for _, d := range conf.Blackouts {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return nil, fmt.Errorf("blackout %q must be a YYYY-MM-DD date", d)
+		}
+		s.blackouts[d] = true
+	}
+	return s, nil
+}
+
+func parseClock(v string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(v))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return t.Sub(midnight(t))
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func (s *schedule) allows(t time.Time) bool {
+	if s.blackouts[t.Format("2006-01-02")] {
+		return false
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(sinceMidnight(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAllowed returns the first moment from t on when captures may run, or a
+// zero time if there is none within a year.
+func (s *schedule) nextAllowed(t time.Time) time.Time {
+	if s.allows(t) {
+		return t
+	}
+
+	day := midnight(t)
+	for i := 0; i <= 366; i++ {
+		var starts []time.Time
+		if len(s.windows) == 0 {
+			starts = append(starts, day)
+		}
+		for _, w := range s.windows {
+			starts = append(starts, day.Add(w.from))
+		}
+		for _, start := range starts {
+			if start.After(t) && s.allows(start) {
+				return start
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// admit blocks until captures are allowed, or returns an error when they
+// aren't and the schedule says to skip.
+func (s *schedule) admit(logger *log.Logger) error {
+	if s == nil {
+		return nil
+	}
+
+	now := time.Now()
+	next := s.nextAllowed(now)
+	if next.Equal(now) {
+		return nil
+	}
+	if s.skip || next.IsZero() {
+		return fmt.Errorf("outside the capture window")
+	}
+
+	logger.Printf("outside the capture window, waiting until %s", next.Format(time.RFC1123))
+	time.Sleep(time.Until(next))
+	return nil
+}
@@ -0,0 +1,303 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: screenshoter.proto
+
+// The gRPC flavour of the screenshot server API, used for servers configured
+// with `protocol: grpc`. Regenerate the Go code with `go generate`.
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RenderRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The action parameters of the HTTP API (Url, Width, Height, FileName,
+	// TimeoutSeconds, Style, ...), with the same names and values.
+	Params        map[string]string `protobuf:"bytes,1,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderRequest) Reset() {
+	*x = RenderRequest{}
+	mi := &file_screenshoter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderRequest) ProtoMessage() {}
+
+func (x *RenderRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_screenshoter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderRequest.ProtoReflect.Descriptor instead.
+func (*RenderRequest) Descriptor() ([]byte, []int) {
+	return file_screenshoter_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RenderRequest) GetParams() map[string]string {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type RenderResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Set on the first message of the stream only.
+	ContentType string `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// The size of the image when known up front, 0 otherwise.
+	Size int64 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	// The next chunk of the image.
+	Data          []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderResponse) Reset() {
+	*x = RenderResponse{}
+	mi := &file_screenshoter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderResponse) ProtoMessage() {}
+
+func (x *RenderResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_screenshoter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderResponse.ProtoReflect.Descriptor instead.
+func (*RenderResponse) Descriptor() ([]byte, []int) {
+	return file_screenshoter_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RenderResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *RenderResponse) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *RenderResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+type CapabilitiesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CapabilitiesRequest) Reset() {
+	*x = CapabilitiesRequest{}
+	mi := &file_screenshoter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CapabilitiesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CapabilitiesRequest) ProtoMessage() {}
+
+func (x *CapabilitiesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_screenshoter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CapabilitiesRequest.ProtoReflect.Descriptor instead.
+func (*CapabilitiesRequest) Descriptor() ([]byte, []int) {
+	return file_screenshoter_proto_rawDescGZIP(), []int{2}
+}
+
+type CapabilitiesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Parameters    []string               `protobuf:"bytes,2,rep,name=parameters,proto3" json:"parameters,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CapabilitiesResponse) Reset() {
+	*x = CapabilitiesResponse{}
+	mi := &file_screenshoter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CapabilitiesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CapabilitiesResponse) ProtoMessage() {}
+
+func (x *CapabilitiesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_screenshoter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CapabilitiesResponse.ProtoReflect.Descriptor instead.
+func (*CapabilitiesResponse) Descriptor() ([]byte, []int) {
+	return file_screenshoter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *CapabilitiesResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *CapabilitiesResponse) GetParameters() []string {
+	if x != nil {
+		return x.Parameters
+	}
+	return nil
+}
+
+var File_screenshoter_proto protoreflect.FileDescriptor
+
+const file_screenshoter_proto_rawDesc = "" +
+	"\n" +
+	"\x12screenshoter.proto\x12\x0fscreenshoter.v1\"\x8e\x01\n" +
+	"\rRenderRequest\x12B\n" +
+	"\x06params\x18\x01 \x03(\v2*.screenshoter.v1.RenderRequest.ParamsEntryR\x06params\x1a9\n" +
+	"\vParamsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"[\n" +
+	"\x0eRenderResponse\x12!\n" +
+	"\fcontent_type\x18\x01 \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\"\x15\n" +
+	"\x13CapabilitiesRequest\"P\n" +
+	"\x14CapabilitiesResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x1e\n" +
+	"\n" +
+	"parameters\x18\x02 \x03(\tR\n" +
+	"parameters2\xb7\x01\n" +
+	"\vScreenshots\x12K\n" +
+	"\x06Render\x12\x1e.screenshoter.v1.RenderRequest\x1a\x1f.screenshoter.v1.RenderResponse0\x01\x12[\n" +
+	"\fCapabilities\x12$.screenshoter.v1.CapabilitiesRequest\x1a%.screenshoter.v1.CapabilitiesResponseB&Z$github.com/eubelov/screenshoter;mainb\x06proto3"
+
+var (
+	file_screenshoter_proto_rawDescOnce sync.Once
+	file_screenshoter_proto_rawDescData []byte
+)
+
+func file_screenshoter_proto_rawDescGZIP() []byte {
+	file_screenshoter_proto_rawDescOnce.Do(func() {
+		file_screenshoter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_screenshoter_proto_rawDesc), len(file_screenshoter_proto_rawDesc)))
+	})
+	return file_screenshoter_proto_rawDescData
+}
+
+var file_screenshoter_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_screenshoter_proto_goTypes = []any{
+	(*RenderRequest)(nil),        // 0: screenshoter.v1.RenderRequest
+	(*RenderResponse)(nil),       // 1: screenshoter.v1.RenderResponse
+	(*CapabilitiesRequest)(nil),  // 2: screenshoter.v1.CapabilitiesRequest
+	(*CapabilitiesResponse)(nil), // 3: screenshoter.v1.CapabilitiesResponse
+	nil,                          // 4: screenshoter.v1.RenderRequest.ParamsEntry
+}
+var file_screenshoter_proto_depIdxs = []int32{
+	4, // 0: screenshoter.v1.RenderRequest.params:type_name -> screenshoter.v1.RenderRequest.ParamsEntry
+	0, // 1: screenshoter.v1.Screenshots.Render:input_type -> screenshoter.v1.RenderRequest
+	2, // 2: screenshoter.v1.Screenshots.Capabilities:input_type -> screenshoter.v1.CapabilitiesRequest
+	1, // 3: screenshoter.v1.Screenshots.Render:output_type -> screenshoter.v1.RenderResponse
+	3, // 4: screenshoter.v1.Screenshots.Capabilities:output_type -> screenshoter.v1.CapabilitiesResponse
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_screenshoter_proto_init() }
+func file_screenshoter_proto_init() {
+	if File_screenshoter_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_screenshoter_proto_rawDesc), len(file_screenshoter_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_screenshoter_proto_goTypes,
+		DependencyIndexes: file_screenshoter_proto_depIdxs,
+		MessageInfos:      file_screenshoter_proto_msgTypes,
+	}.Build()
+	File_screenshoter_proto = out.File
+	file_screenshoter_proto_goTypes = nil
+	file_screenshoter_proto_depIdxs = nil
+}
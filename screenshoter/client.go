@@ -0,0 +1,162 @@
+package screenshoter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DefaultActionPath is the render endpoint of the screenshot server.
+const DefaultActionPath = "api/screenshots"
+
+// Client renders pages on a screenshot server. Its fields must not change
+// while captures are running.
+type Client struct {
+	// Server is the base URL of the screenshot server, such as
+	// http://localhost:5601.
+	Server string
+	// ActionPath is the render endpoint under Server.
+	ActionPath string
+	// Post sends the parameters as a JSON body instead of a query string,
+	// which survives long URLs with fragments and encoded characters.
+	Post bool
+	// ParamNames renames the action parameters for servers whose API names
+	// them differently, see RenameParams.
+	ParamNames map[string]string
+	// Header is sent with every render request.
+	Header     http.Header
+	HTTPClient *http.Client
+	// Concurrency bounds the renders CaptureBatch runs at a time.
+	Concurrency int
+}
+
+// NewClient returns a client for the screenshot server at server.
+func NewClient(server string) *Client {
+	return &Client{Server: server, ActionPath: DefaultActionPath, HTTPClient: http.DefaultClient, Concurrency: 2}
+}
+
+// Shot is a captured page.
+type Shot struct {
+	URL         string
+	Image       []byte
+	ContentType string
+	// StatusCode is the answer of the screenshot server, 0 when it couldn't
+	// be reached.
+	StatusCode int
+	// Err is set for the failed captures of CaptureBatch.
+	Err error
+}
+
+// NewRequest builds the render request for the action parameters params,
+// see CaptureOptions.Params.
+func (c *Client) NewRequest(ctx context.Context, params url.Values) (*http.Request, error) {
+	params = RenameParams(params, c.ParamNames)
+	target := strings.TrimSuffix(c.Server, "/") + "/" + strings.TrimPrefix(c.ActionPath, "/")
+
+	var req *http.Request
+	var err error
+	if c.Post {
+		flat := make(map[string]string, len(params))
+		for k := range params {
+			flat[k] = params.Get(k)
+		}
+		body, _ := json.Marshal(flat)
+		if req, err = http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body)); err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "GET", target+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't build request: %w", err)
+	}
+
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// Capture renders u and returns the image.
+func (c *Client) Capture(ctx context.Context, u string, opts CaptureOptions) (*Shot, error) {
+	req, err := c.NewRequest(ctx, opts.Params(u))
+	if err != nil {
+		return nil, err
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the request URL embeds the page URL; keep only the cause
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &Shot{URL: u}, fmt.Errorf("request to server failed: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	shot := &Shot{URL: u, StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	if resp.StatusCode > 299 {
+		// drain short error bodies so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return shot, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if !strings.HasPrefix(shot.ContentType, "image/") {
+		shot.ContentType = contentTypes[opts.format()]
+	}
+	if shot.Image, err = io.ReadAll(resp.Body); err != nil {
+		return shot, fmt.Errorf("download interrupted: %w", err)
+	}
+	return shot, nil
+}
+
+// CaptureBatch captures urls with up to Concurrency renders at a time. The
+// shots are returned in the order of urls, the failed ones with Err set;
+// once ctx is done the remaining URLs fail with its error.
+func (c *Client) CaptureBatch(ctx context.Context, urls []string, opts CaptureOptions) []Shot {
+	shots := make([]Shot, len(urls))
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				shot, err := c.Capture(ctx, urls[i], opts)
+				if shot == nil {
+					shot = &Shot{URL: urls[i]}
+				}
+				shot.Err = err
+				shots[i] = *shot
+			}
+		}()
+	}
+
+	for i := range urls {
+		if ctx.Err() != nil {
+			shots[i] = Shot{URL: urls[i], Err: ctx.Err()}
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return shots
+}
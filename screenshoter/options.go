@@ -0,0 +1,150 @@
+// Package screenshoter captures web pages through a screenshot server, the
+// way the screenshoter command does, for programs that embed batch
+// screenshotting instead of shelling out to the binary.
+package screenshoter
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// CaptureOptions describes how a page is rendered. The zero value of a field
+// leaves the server default in place.
+type CaptureOptions struct {
+	Width  int
+	Height int
+	// Delay is how long the server waits after the page loaded.
+	Delay time.Duration
+	// Format is the image format the server produces (jpeg, png or webp);
+	// it only names the content type when the server doesn't.
+	Format string
+	// FileName is the name the server gives the image, screenshot.<Format>
+	// by default.
+	FileName string
+
+	DisableJavaScript bool
+	// Headers and Cookies are sent with the page request.
+	Headers map[string]string
+	Cookies map[string]string
+	// Style and Script are injected before capture, InitScript before any
+	// script of the page runs.
+	Style      string
+	Script     string
+	InitScript string
+
+	// WaitForFunction is a JavaScript expression the renderer waits to
+	// become truthy, for up to WaitForTimeout.
+	WaitForFunction string
+	WaitForTimeout  time.Duration
+
+	// ScrollPercent is the scroll offset to capture at; nil captures the top
+	// of the page without scrolling.
+	ScrollPercent *int
+	// FrameSelector captures the iframe it selects instead of the page.
+	FrameSelector string
+	// Proxy is the proxy the renderer fetches the page through.
+	Proxy string
+
+	// Extra are further action parameters sent as they are, for features of
+	// customized servers. They don't replace the parameters set above.
+	Extra map[string]string
+}
+
+// Params returns the action parameters of the screenshot server API for
+// capturing u.
+func (o CaptureOptions) Params(u string) url.Values {
+	fileName := o.FileName
+	if fileName == "" {
+		fileName = "screenshot." + o.format()
+	}
+
+	params := url.Values{
+		"TimeoutSeconds": {seconds(o.Delay)},
+		"FileName":       {fileName},
+		"Url":            {u},
+		"Width":          {strconv.Itoa(o.Width)},
+		"Height":         {strconv.Itoa(o.Height)},
+	}
+	if o.DisableJavaScript {
+		params.Set("DisableJavaScript", "true")
+	}
+	if len(o.Headers) > 0 {
+		headers, _ := json.Marshal(o.Headers)
+		params.Set("Headers", string(headers))
+	}
+	if len(o.Cookies) > 0 {
+		cookies, _ := json.Marshal(o.Cookies)
+		params.Set("Cookies", string(cookies))
+	}
+	if o.WaitForFunction != "" {
+		params.Set("WaitForFunction", o.WaitForFunction)
+		params.Set("WaitForFunctionTimeoutSeconds", seconds(o.WaitForTimeout))
+	}
+	if o.Style != "" {
+		params.Set("Style", o.Style)
+	}
+	if o.Script != "" {
+		params.Set("Script", o.Script)
+	}
+	if o.InitScript != "" {
+		params.Set("InitScript", o.InitScript)
+	}
+	if o.ScrollPercent != nil {
+		params.Set("ScrollPercent", strconv.Itoa(*o.ScrollPercent))
+	}
+	if o.FrameSelector != "" {
+		params.Set("FrameSelector", o.FrameSelector)
+	}
+	if o.Proxy != "" {
+		params.Set("Proxy", o.Proxy)
+	}
+	for k, v := range o.Extra {
+		if _, ok := params[k]; !ok {
+			params.Set(k, v)
+		}
+	}
+	return params
+}
+
+// RenameParams returns params with the parameters named in names renamed to
+// their values; a parameter renamed to "" is left out. The others keep their
+// names.
+func RenameParams(params url.Values, names map[string]string) url.Values {
+	if len(names) == 0 {
+		return params
+	}
+
+	renamed := make(url.Values, len(params))
+	for k, v := range params {
+		if name, ok := names[k]; ok {
+			if name == "" {
+				continue
+			}
+			k = name
+		}
+		renamed[k] = v
+	}
+	return renamed
+}
+
+func (o CaptureOptions) format() string {
+	if o.Format == "" {
+		return "jpeg"
+	}
+	return o.Format
+}
+
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// seconds renders d in the whole seconds the API takes, rounded up so that a
+// sub-second wait doesn't become no wait at all.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
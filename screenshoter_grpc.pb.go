@@ -0,0 +1,176 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: screenshoter.proto
+
+// The gRPC flavour of the screenshot server API, used for servers configured
+// with `protocol: grpc`. Regenerate the Go code with `go generate`.
+
+package main
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Screenshots_Render_FullMethodName       = "/screenshoter.v1.Screenshots/Render"
+	Screenshots_Capabilities_FullMethodName = "/screenshoter.v1.Screenshots/Capabilities"
+)
+
+// ScreenshotsClient is the client API for Screenshots service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScreenshotsClient interface {
+	// Render renders a page and streams the image back in chunks. Failures are
+	// reported with gRPC status codes, read like the HTTP statuses they match:
+	// INTERNAL, UNAVAILABLE and DEADLINE_EXCEEDED count against the server,
+	// INVALID_ARGUMENT, NOT_FOUND and the like against the page.
+	Render(ctx context.Context, in *RenderRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RenderResponse], error)
+	// Capabilities lists the render parameters the server understands.
+	Capabilities(ctx context.Context, in *CapabilitiesRequest, opts ...grpc.CallOption) (*CapabilitiesResponse, error)
+}
+
+type screenshotsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScreenshotsClient(cc grpc.ClientConnInterface) ScreenshotsClient {
+	return &screenshotsClient{cc}
+}
+
+func (c *screenshotsClient) Render(ctx context.Context, in *RenderRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RenderResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Screenshots_ServiceDesc.Streams[0], Screenshots_Render_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RenderRequest, RenderResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Screenshots_RenderClient = grpc.ServerStreamingClient[RenderResponse]
+
+func (c *screenshotsClient) Capabilities(ctx context.Context, in *CapabilitiesRequest, opts ...grpc.CallOption) (*CapabilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CapabilitiesResponse)
+	err := c.cc.Invoke(ctx, Screenshots_Capabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ScreenshotsServer is the server API for Screenshots service.
+// All implementations must embed UnimplementedScreenshotsServer
+// for forward compatibility.
+type ScreenshotsServer interface {
+	// Render renders a page and streams the image back in chunks. Failures are
+	// reported with gRPC status codes, read like the HTTP statuses they match:
+	// INTERNAL, UNAVAILABLE and DEADLINE_EXCEEDED count against the server,
+	// INVALID_ARGUMENT, NOT_FOUND and the like against the page.
+	Render(*RenderRequest, grpc.ServerStreamingServer[RenderResponse]) error
+	// Capabilities lists the render parameters the server understands.
+	Capabilities(context.Context, *CapabilitiesRequest) (*CapabilitiesResponse, error)
+	mustEmbedUnimplementedScreenshotsServer()
+}
+
+// UnimplementedScreenshotsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedScreenshotsServer struct{}
+
+func (UnimplementedScreenshotsServer) Render(*RenderRequest, grpc.ServerStreamingServer[RenderResponse]) error {
+	return status.Error(codes.Unimplemented, "method Render not implemented")
+}
+func (UnimplementedScreenshotsServer) Capabilities(context.Context, *CapabilitiesRequest) (*CapabilitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (UnimplementedScreenshotsServer) mustEmbedUnimplementedScreenshotsServer() {}
+func (UnimplementedScreenshotsServer) testEmbeddedByValue()                     {}
+
+// UnsafeScreenshotsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScreenshotsServer will
+// result in compilation errors.
+type UnsafeScreenshotsServer interface {
+	mustEmbedUnimplementedScreenshotsServer()
+}
+
+func RegisterScreenshotsServer(s grpc.ServiceRegistrar, srv ScreenshotsServer) {
+	// If the following call panics, it indicates UnimplementedScreenshotsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Screenshots_ServiceDesc, srv)
+}
+
+func _Screenshots_Render_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RenderRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScreenshotsServer).Render(m, &grpc.GenericServerStream[RenderRequest, RenderResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Screenshots_RenderServer = grpc.ServerStreamingServer[RenderResponse]
+
+func _Screenshots_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScreenshotsServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Screenshots_Capabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScreenshotsServer).Capabilities(ctx, req.(*CapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Screenshots_ServiceDesc is the grpc.ServiceDesc for Screenshots service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Screenshots_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "screenshoter.v1.Screenshots",
+	HandlerType: (*ScreenshotsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Capabilities",
+			Handler:    _Screenshots_Capabilities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Render",
+			Handler:       _Screenshots_Render_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "screenshoter.proto",
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// searchFields are the result fields search expressions can test.
+var searchFields = map[string]func(run *runReport, res *captureResult) []string{
+	"run":        func(run *runReport, res *captureResult) []string { return []string{run.RunID} },
+	"captureid":  func(run *runReport, res *captureResult) []string { return []string{res.CaptureID} },
+	"url":        func(run *runReport, res *captureResult) []string { return []string{res.URL} },
+	"domain":     func(run *runReport, res *captureResult) []string { return []string{hostOf(res.URL)} },
+	"status":     func(run *runReport, res *captureResult) []string { return []string{res.Status} },
+	"error":      func(run *runReport, res *captureResult) []string { return []string{res.Error} },
+	"statuscode": func(run *runReport, res *captureResult) []string { return []string{strconv.Itoa(res.StatusCode)} },
+	"bytes":      func(run *runReport, res *captureResult) []string { return []string{strconv.FormatInt(res.Bytes, 10)} },
+	"durationms": func(run *runReport, res *captureResult) []string {
+		return []string{strconv.FormatInt(res.DurationMs, 10)}
+	},
+	"filename":    func(run *runReport, res *captureResult) []string { return []string{res.FileName} },
+	"server":      func(run *runReport, res *captureResult) []string { return []string{res.Server} },
+	"environment": func(run *runReport, res *captureResult) []string { return []string{res.Environment} },
+	"line":        func(run *runReport, res *captureResult) []string { return []string{strconv.Itoa(res.Line)} },
+	"startedat": func(run *runReport, res *captureResult) []string {
+		return []string{res.StartedAt.Format("2006-01-02T15:04:05Z07:00")}
+	},
+	"slow": func(run *runReport, res *captureResult) []string { return []string{strconv.FormatBool(res.Slow)} },
+	"tag":  func(run *runReport, res *captureResult) []string { return res.Tags },
+}
+
+// searchExpr is a parsed search expression.
+type searchExpr interface {
+	match(run *runReport, res *captureResult) bool
+}
+
+type andExpr []searchExpr
+type orExpr []searchExpr
+type notExpr struct{ expr searchExpr }
+
+func (e andExpr) match(run *runReport, res *captureResult) bool {
+	for _, sub := range e {
+		if !sub.match(run, res) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e orExpr) match(run *runReport, res *captureResult) bool {
+	for _, sub := range e {
+		if sub.match(run, res) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e notExpr) match(run *runReport, res *captureResult) bool {
+	return !e.expr.match(run, res)
+}
+
+// condition compares a field: = and != ignore case, ~ and !~ match a regular
+// expression, and <, <=, > and >= compare numbers, or strings such as dates
+// when either side isn't one.
+type condition struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (c *condition) match(run *runReport, res *captureResult) bool {
+	matched := false
+	for _, v := range searchFields[c.field](run, res) {
+		if matched = c.compare(v); matched {
+			break
+		}
+	}
+	if c.op == "!=" || c.op == "!~" {
+		return !matched
+	}
+	return matched
+}
+
+// compare reports whether v satisfies the condition, != and !~ testing for
+// equality and a match like their positive forms.
+func (c *condition) compare(v string) bool {
+	switch c.op {
+	case "=", "!=":
+		return strings.EqualFold(v, c.value)
+	case "~", "!~":
+		return c.re.MatchString(v)
+	}
+
+	cmp := strings.Compare(v, c.value)
+	a, aerr := strconv.ParseFloat(v, 64)
+	b, berr := strconv.ParseFloat(c.value, 64)
+	if aerr == nil && berr == nil {
+		cmp = 0
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// parseSearch parses expressions such as
+//
+//	status=failed AND (domain=example.com OR tag=home) AND NOT error~timeout
+//
+// where AND binds tighter than OR, and values with spaces are quoted.
+func parseSearch(s string) (searchExpr, error) {
+	tokens, err := searchTokens(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchParser{tokens: tokens}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+var searchOps = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+// searchTokens splits s into words, quoted strings, operators and
+// parentheses. Quoted strings keep their leading quote to tell them from
+// keywords.
+func searchTokens(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, s[i:i+1+end])
+			i += end + 2
+		default:
+			op := ""
+			for _, o := range searchOps {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, op)
+				i += len(op)
+				continue
+			}
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()\"=!~<>", rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func isSearchOp(t string) bool {
+	for _, o := range searchOps {
+		if t == o {
+			return true
+		}
+	}
+	return false
+}
+
+func isSearchKeyword(t string) bool {
+	return strings.EqualFold(t, "AND") || strings.EqualFold(t, "OR") || strings.EqualFold(t, "NOT")
+}
+
+type searchParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *searchParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *searchParser) take() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *searchParser) or() (searchExpr, error) {
+	var terms orExpr
+	for {
+		term, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if !strings.EqualFold(p.peek(), "OR") {
+			break
+		}
+		p.take()
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *searchParser) and() (searchExpr, error) {
+	var terms andExpr
+	for {
+		term, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if !strings.EqualFold(p.peek(), "AND") {
+			break
+		}
+		p.take()
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *searchParser) unary() (searchExpr, error) {
+	switch t := p.take(); {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case strings.EqualFold(t, "NOT"):
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case t == "(":
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.take() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return expr, nil
+	default:
+		field := strings.ToLower(t)
+		if _, ok := searchFields[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", t)
+		}
+		c := &condition{field: field, op: p.take()}
+		if !isSearchOp(c.op) {
+			return nil, fmt.Errorf("%s must be followed by an operator (=, !=, ~, !~, <, <=, > or >=)", t)
+		}
+		value := p.take()
+		if value == "" || value == "(" || value == ")" || isSearchOp(value) || isSearchKeyword(value) {
+			return nil, fmt.Errorf("%s %s needs a value, quoted if it is an operator or keyword", t, c.op)
+		}
+		c.value = strings.TrimPrefix(value, "\"")
+		if c.op == "~" || c.op == "!~" {
+			var err error
+			if c.re, err = regexp.Compile(c.value); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+}
+
+func runSearch(c *command, args []string) error {
+	fs := c.flagSet(false)
+	keyFile := fs.String("key", "", "File with the hex-encoded 32-byte key, for reports written with -encrypt")
+	asJSON := fs.Bool("json", false, "Print the matching results as JSON lines instead of a table")
+	_ = fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	expr, err := parseSearch(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("invalid search: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	matches := 0
+	for _, path := range fs.Args()[1:] {
+		r, err := readReport(path, *keyFile)
+		if err != nil {
+			return err
+		}
+		for _, res := range r.Results {
+			if !expr.match(r, res) {
+				continue
+			}
+			matches++
+			if *asJSON {
+				if err = enc.Encode(res); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", r.RunID, res.Status, res.URL, res.FileName, res.Error)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d results\n", matches)
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSearch(t *testing.T) {
+	run := &runReport{RunID: "r1"}
+	failed := &captureResult{URL: "https://example.com/a", Status: statusFailed, Error: "render timeout", StatusCode: 503, Tags: []string{"home", "nav"}}
+	saved := &captureResult{URL: "https://shop.example.org/b", Status: statusSaved, StatusCode: 200, Bytes: 2048, FileName: "b c.png"}
+	results := map[string]*captureResult{"failed": failed, "saved": saved}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`status=failed`, []string{"failed"}},
+		{`STATUS=FAILED`, []string{"failed"}},
+		{`status!=failed`, []string{"saved"}},
+		{`error~time`, []string{"failed"}},
+		{`error!~time`, []string{"saved"}},
+		{`bytes>1000`, []string{"saved"}},
+		{`bytes<=1000`, []string{"failed"}},
+		// numbers compare as numbers, not as strings
+		{`statuscode>=503`, []string{"failed"}},
+		{`statuscode<1000`, []string{"failed", "saved"}},
+		{`tag=nav`, []string{"failed"}},
+		{`filename="b c.png"`, []string{"saved"}},
+		{`error=""`, []string{"saved"}},
+		{`status="AND"`, nil},
+		// AND binds tighter than OR
+		{`status=saved OR status=failed AND tag=none`, []string{"saved"}},
+		{`(status=saved OR status=failed) AND tag=none`, nil},
+		{`status=failed AND tag=home OR bytes>1000`, []string{"failed", "saved"}},
+		{`NOT status=failed`, []string{"saved"}},
+		{`NOT NOT status=failed`, []string{"failed"}},
+		{`not (status=failed or domain=shop.example.org)`, nil},
+		{`NOT status=failed OR tag=home`, []string{"failed", "saved"}},
+		{`run=r1 AND domain=example.com`, []string{"failed"}},
+	}
+	for _, tt := range tests {
+		expr, err := parseSearch(tt.expr)
+		if err != nil {
+			t.Errorf("parseSearch(%q): %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, name := range []string{"failed", "saved"} {
+			if expr.match(run, results[name]) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%q matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchErrors(t *testing.T) {
+	tests := []struct {
+		expr, err string
+	}{
+		{``, "unexpected end"},
+		{`status`, "must be followed by an operator"},
+		{`status failed`, "must be followed by an operator"},
+		{`status=`, "needs a value"},
+		{`status==x`, "needs a value"},
+		{`status=<x`, "needs a value"},
+		{`status=AND`, "needs a value"},
+		{`status=not`, "needs a value"},
+		{`status=(`, "needs a value"},
+		{`color=red`, "unknown field"},
+		{`(status=failed`, "missing )"},
+		{`status=failed)`, `unexpected ")"`},
+		{`status=failed tag=home`, `unexpected "tag"`},
+		{`status=failed AND`, "unexpected end"},
+		{`NOT`, "unexpected end"},
+		{`error="timeout`, "unterminated quote"},
+		{`error~"("`, "error parsing regexp"},
+	}
+	for _, tt := range tests {
+		_, err := parseSearch(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseSearch(%q) = %v, want an error containing %q", tt.expr, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job states of the serve command.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// maxJobBody caps the URL list a job may be submitted with.
+const maxJobBody = 32 << 20
+
+// serveJob is a batch submitted to the serve command. Every job is captured by a
+// capture run of its own in a directory under -jobsDir, holding job.json,
+// the URL list, the report, the log and the screenshots in out/.
+type serveJob struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	URLs       int       `json:"urls"`
+	Args       []string  `json:"args,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	StartedAt  time.Time `json:"startedAt,omitempty"`
+	FinishedAt time.Time `json:"finishedAt,omitempty"`
+	// Resume is set for jobs interrupted by a restart of the server.
+	Resume bool `json:"resume,omitempty"`
+}
+
+// jobRequest is the JSON body of POST /jobs. A text/plain body is a URL list
+// captured with the defaults instead.
+type jobRequest struct {
+	URLs        []string `json:"urls"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	Delay       int      `json:"delay,omitempty"`
+	ImageFormat string   `json:"imageFormat,omitempty"`
+}
+
+// args turns the options of the request into capture flags.
+func (r jobRequest) args() []string {
+	var args []string
+	if r.Width > 0 {
+		args = append(args, "-width", strconv.Itoa(r.Width))
+	}
+	if r.Height > 0 {
+		args = append(args, "-height", strconv.Itoa(r.Height))
+	}
+	if r.Delay > 0 {
+		args = append(args, "-delay", strconv.Itoa(r.Delay))
+	}
+	if r.ImageFormat != "" {
+		args = append(args, "-imageFormat", r.ImageFormat)
+	}
+	return args
+}
+
+type jobServer struct {
+	mu       sync.Mutex
+	dir      string
+	defaults []string
+	token    string
+	jobs     map[string]*serveJob
+	queue    chan *serveJob
+	logger   *log.Logger
+}
+
+// runServe implements `screenshoter serve [flags]`. Capture flags given to
+// serve apply to every job.
+func runServe(c *command, args []string) error {
+	fs := c.flagSet(true)
+	listen := fs.String("listen", "127.0.0.1:8080", "Address the job API listens on; other than loopback only with SCREENSHOTER_SERVE_TOKEN set")
+	jobsDir := fs.String("jobsDir", "jobs", "Directory jobs are captured into")
+	workers := fs.Int("jobs", 1, "Number of jobs captured at the same time")
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// forward the capture flags that were set, but not the ones of serve
+	// or those every job sets for itself
+	own := map[string]bool{"listen": true, "jobsDir": true, "jobs": true, "file": true, "sitemap": true, "feed": true, "outputDir": true, "output": true, "report": true, "stateFile": true, "failedFile": true, "resume": true, "force": true}
+	var defaults []string
+	fs.Visit(func(f *flag.Flag) {
+		if own[f.Name] {
+			return
+		}
+		values := []string{f.Value.String()}
+		if l, ok := f.Value.(*stringList); ok {
+			values = *l
+		}
+		for _, v := range values {
+			defaults = append(defaults, "-"+f.Name+"="+v)
+		}
+	})
+
+	s := &jobServer{
+		dir:      *jobsDir,
+		defaults: defaults,
+		token:    os.Getenv("SCREENSHOTER_SERVE_TOKEN"),
+		jobs:     map[string]*serveJob{},
+		queue:    make(chan *serveJob, 1<<16),
+		logger:   log.New(newConsoleWriter(os.Stdout, *colorMode), "", log.LstdFlags),
+	}
+	if s.token == "" && !loopback(*listen) {
+		return fmt.Errorf("the job API would be open to anyone on %s, set SCREENSHOTER_SERVE_TOKEN or listen on a loopback address", *listen)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.submit)
+	mux.HandleFunc("GET /jobs", s.list)
+	mux.HandleFunc("GET /jobs/{id}", s.status)
+	mux.HandleFunc("GET /jobs/{id}/report", s.report)
+	mux.HandleFunc("GET /jobs/{id}/files/{name...}", s.file)
+	srv := &http.Server{Addr: *listen, Handler: s.authorize(mux)}
+
+	// on shutdown running jobs are stopped and left running in job.json,
+	// to be resumed by the next server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	s.logger.Printf("serving the job API on %s, jobs in %s", *listen, s.dir)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	wg.Wait()
+	return nil
+}
+
+// loopback reports whether addr only accepts connections from this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// load picks up the jobs of a previous server. Queued jobs are queued again
+// and interrupted ones resumed from their state file.
+func (s *jobServer) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var pending []*serveJob
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(s.dir, e.Name(), "job.json"))
+		if err != nil {
+			continue
+		}
+		j := &serveJob{}
+		if err = json.Unmarshal(data, j); err != nil || j.ID != e.Name() {
+			s.logger.Printf("ignoring job %s: unreadable job.json", e.Name())
+			continue
+		}
+		s.jobs[j.ID] = j
+		switch j.Status {
+		case jobRunning:
+			j.Status, j.Resume = jobQueued, true
+			fallthrough
+		case jobQueued:
+			pending = append(pending, j)
+		}
+	}
+
+	sort.Slice(pending, func(a, b int) bool { return pending[a].CreatedAt.Before(pending[b].CreatedAt) })
+	for _, j := range pending {
+		s.queue <- j
+	}
+	if len(pending) > 0 {
+		s.logger.Printf("resuming %d unfinished jobs", len(pending))
+	}
+	return nil
+}
+
+func (s *jobServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxJobBody)
+	var req jobRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				req.URLs = append(req.URLs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(req.URLs) == 0 {
+		http.Error(w, "a job needs at least one URL", http.StatusBadRequest)
+		return
+	}
+	if req.ImageFormat != "" {
+		if _, err := parseImageFormats(req.ImageFormat); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	j := &serveJob{ID: uuid.New().String(), Status: jobQueued, URLs: len(req.URLs), Args: req.args(), CreatedAt: time.Now()}
+	dir := filepath.Join(s.dir, j.ID)
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "urls.txt"), []byte(strings.Join(req.URLs, "\n")+"\n"), 0644)
+	}
+	if err == nil {
+		err = s.save(j)
+	}
+	if err != nil {
+		s.logger.Printf("can't create job: %v", err)
+		http.Error(w, "can't create job", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	s.queue <- j
+	s.logger.Printf("job %s queued with %d URLs", j.ID, j.URLs)
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	s.reply(w, http.StatusAccepted, j)
+}
+
+func (s *jobServer) list(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	jobs := make([]serveJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.Before(jobs[b].CreatedAt) })
+	s.reply(w, http.StatusOK, jobs)
+}
+
+// lookup returns a copy of the job of the request, answering 404 when there
+// is none.
+func (s *jobServer) lookup(w http.ResponseWriter, r *http.Request) (serveJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return serveJob{}, false
+	}
+	return *j, true
+}
+
+func (s *jobServer) status(w http.ResponseWriter, r *http.Request) {
+	if j, ok := s.lookup(w, r); ok {
+		s.reply(w, http.StatusOK, j)
+	}
+}
+
+// report returns the run report of a finished job, listing every result and
+// the file it was saved as.
+func (s *jobServer) report(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	if j.Status != jobDone && j.Status != jobFailed {
+		http.Error(w, "job is "+j.Status, http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, filepath.Join(s.dir, j.ID, "report.json"))
+}
+
+// file downloads a screenshot of a job by the file name of its result.
+func (s *jobServer) file(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+	r.URL.Path = "/" + r.PathValue("name")
+	http.FileServer(http.Dir(filepath.Join(s.dir, j.ID, "out"))).ServeHTTP(w, r)
+}
+
+func (s *jobServer) reply(w http.ResponseWriter, code int, v interface{}) {
+	data, err := marshalIndented(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(data)
+}
+
+// save writes the state of j to its directory.
+func (s *jobServer) save(j *serveJob) error {
+	s.mu.Lock()
+	data, err := marshalIndented(j)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, j.ID, "job.json")
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *jobServer) update(j *serveJob, change func(j *serveJob)) {
+	s.mu.Lock()
+	change(j)
+	s.mu.Unlock()
+	if err := s.save(j); err != nil {
+		s.logger.Printf("can't save job %s: %v", j.ID, err)
+	}
+}
+
+func (s *jobServer) work(ctx context.Context) {
+	for {
+		var j *serveJob
+		select {
+		case <-ctx.Done():
+			return
+		case j = <-s.queue:
+		}
+
+		s.update(j, func(j *serveJob) { j.Status, j.StartedAt = jobRunning, time.Now() })
+		s.logger.Printf("job %s started", j.ID)
+
+		err := s.run(ctx, j)
+		if ctx.Err() != nil {
+			s.logger.Printf("job %s interrupted", j.ID)
+			return
+		}
+		s.update(j, func(j *serveJob) {
+			j.Status, j.FinishedAt = jobDone, time.Now()
+			if err != nil {
+				j.Status, j.Error = jobFailed, err.Error()
+			}
+		})
+		if err != nil {
+			s.logger.Printf("job %s failed: %v", j.ID, err)
+		} else {
+			s.logger.Printf("job %s done", j.ID)
+		}
+	}
+}
+
+// run captures j with a capture run of this binary, so a job that fails
+// can't take the server down with it.
+func (s *jobServer) run(ctx context.Context, j *serveJob) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(s.dir, j.ID)
+	args := append([]string{"capture"}, s.defaults...)
+	args = append(args, j.Args...)
+	args = append(args,
+		"-file", filepath.Join(dir, "urls.txt"),
+		"-outputDir", filepath.Join(dir, "out"),
+		"-report", filepath.Join(dir, "report.json"),
+		"-stateFile", filepath.Join(dir, "state.jsonl"),
+		"-failedFile", filepath.Join(dir, "failed.txt"),
+		"-color", "never")
+	if j.Resume {
+		// the lock left in out/ can only be that of the interrupted run
+		args = append(args, "-resume", "-force")
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(dir, "log.txt"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.CommandContext(ctx, self, args...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err = cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("capture exited with code %d, see log.txt", exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
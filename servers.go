@@ -0,0 +1,546 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// Policies for spreading renders over the servers of config.yaml.
+const (
+	balanceRoundRobin    = "roundRobin"
+	balanceLeastInFlight = "leastInFlight"
+	// balanceLatency sends each server a share of the renders inversely
+	// proportional to its ping time, measured at startup and with every
+	// health check.
+	balanceLatency = "latency"
+)
+
+// serverConfig is one screenshot server of config.yaml.
+type serverConfig struct {
+	Host             string `yaml:"host"`
+	Port             int    `yaml:"port"`
+	PingPath         string `yaml:"pingPath"`
+	ActionPath       string `yaml:"actionPath"`
+	CapabilitiesPath string `yaml:"capabilitiesPath"`
+	// Protocol is http (the default) or grpc for servers speaking
+	// screenshoter.proto; the paths don't apply to grpc.
+	Protocol string `yaml:"protocol"`
+	// Method is how http renders are requested: GET with the parameters in
+	// the query string (the default), or POST with them as a JSON object,
+	// which survives long URLs with fragments and encoded characters.
+	Method string `yaml:"method"`
+	// Params renames the action parameters for servers that name them
+	// differently, such as Url: target; a parameter renamed to "" isn't
+	// sent.
+	Params map[string]string `yaml:"params"`
+	// ResultHeaders are the response headers render details are read from.
+	ResultHeaders resultHeaders `yaml:"resultHeaders"`
+}
+
+// clientParams maps the parameter names a server advertises back to the
+// ones of the client, undoing Params.
+func (s serverConfig) clientParams(advertised []string) []string {
+	if len(s.Params) == 0 {
+		return advertised
+	}
+
+	client := map[string]string{}
+	for k, v := range s.Params {
+		if v != "" {
+			client[strings.ToLower(v)] = k
+		}
+	}
+	params := make([]string, 0, len(advertised))
+	for _, p := range advertised {
+		if k, ok := client[strings.ToLower(p)]; ok {
+			p = k
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func (s serverConfig) url(p string) string {
+	return fmt.Sprintf("%s:%d/%s", s.Host, s.Port, p)
+}
+
+// name identifies the server in logs and results.
+func (s serverConfig) name() string {
+	return fmt.Sprintf("%s:%d", s.Host, s.Port)
+}
+
+// list returns the servers of config.yaml: the servers list, or the single
+// server section of older configs.
+func (conf *config) list() []serverConfig {
+	if len(conf.Servers) > 0 {
+		return conf.Servers
+	}
+	return []serverConfig{conf.Server}
+}
+
+// errNoServers fails renders while every server is unhealthy; they are
+// retried with -retries in the hope that one recovers.
+var errNoServers = errors.New("no healthy screenshot server")
+
+// serverPool hands out the server each render goes to. A server that fails
+// maxFailures renders in a row, or its ping, is taken out of rotation until
+// a periodic ping finds it back up.
+//
+// With a breaker cooldown the server is a circuit breaker instead: failing
+// maxFailures renders in a row opens it, whether it answers pings or not,
+// and once open for the cooldown a single trial render is let through. The
+// breaker closes when the trial succeeds and opens for another cooldown
+// when it fails. While every breaker is open renders fail straight away,
+// or with breakerWait wait for the next trial.
+type serverPool struct {
+	mu          sync.Mutex
+	policy      string
+	servers     []*serverState
+	next        int
+	maxFailures int
+	cooldown    time.Duration
+	wait        bool
+	logger      *log.Logger
+	stopProbes  chan struct{}
+}
+
+type serverState struct {
+	conf      serverConfig
+	conn      *grpc.ClientConn
+	inFlight  int
+	failures  int
+	unhealthy bool
+	// openedAt is when the breaker of the server last opened, and trial
+	// whether a half-open trial render is in flight.
+	openedAt time.Time
+	trial    bool
+	// latency is a moving average of the ping times, and current the
+	// position of the server in the smooth weighted round-robin of
+	// balanceLatency.
+	latency time.Duration
+	current float64
+}
+
+// latencyFloor is the least latency balanceLatency weighs a server by, so
+// servers on the same network share the renders evenly.
+const latencyFloor = 10 * time.Millisecond
+
+// weight is the share of renders balanceLatency gives s.
+func (s *serverState) weight() float64 {
+	latency := s.latency
+	if latency < latencyFloor {
+		latency = latencyFloor
+	}
+	return float64(time.Second) / float64(latency)
+}
+
+// measured folds the ping time rtt into the latency of s.
+func (s *serverState) measured(rtt time.Duration) {
+	if s.latency == 0 {
+		s.latency = rtt
+		return
+	}
+	s.latency = (3*s.latency + rtt) / 4
+}
+
+func newServerPool(conf *config, maxFailures int, cooldown time.Duration, wait bool, logger *log.Logger) (*serverPool, error) {
+	if cooldown > 0 && maxFailures < 1 {
+		return nil, fmt.Errorf("a breaker cooldown needs a maximum number of failures")
+	}
+	p := &serverPool{policy: conf.Balance, maxFailures: maxFailures, cooldown: cooldown, wait: wait, logger: logger}
+	switch p.policy {
+	case "":
+		p.policy = balanceRoundRobin
+	case balanceRoundRobin, balanceLeastInFlight, balanceLatency:
+	default:
+		return nil, fmt.Errorf("balance must be %s, %s or %s, got %q", balanceRoundRobin, balanceLeastInFlight, balanceLatency, conf.Balance)
+	}
+
+	for _, s := range conf.list() {
+		if s.Host == "" {
+			return nil, fmt.Errorf("every server needs a host")
+		}
+		switch s.Method = strings.ToUpper(s.Method); s.Method {
+		case "":
+			s.Method = http.MethodGet
+		case http.MethodGet, http.MethodPost:
+		default:
+			return nil, fmt.Errorf("server %s: method must be GET or POST, got %q", s.name(), s.Method)
+		}
+		state := &serverState{conf: s}
+		switch s.Protocol {
+		case "", protocolHTTP:
+		case protocolGRPC:
+			conn, err := dialGRPC(s)
+			if err != nil {
+				return nil, fmt.Errorf("server %s: %w", s.name(), err)
+			}
+			state.conn = conn
+		default:
+			return nil, fmt.Errorf("server %s: protocol must be %s or %s, got %q", s.name(), protocolHTTP, protocolGRPC, s.Protocol)
+		}
+		p.servers = append(p.servers, state)
+	}
+	return p, nil
+}
+
+// acquire picks a healthy server for the next render, other than the one
+// named avoid unless it is the only one; release it once the response has
+// been read.
+func (p *serverPool) acquire(avoid string) (*serverState, error) {
+	for {
+		s, retryIn := p.pick(avoid)
+		if s != nil {
+			return s, nil
+		}
+		if !p.wait || retryIn <= 0 {
+			return nil, errNoServers
+		}
+		time.Sleep(retryIn)
+	}
+}
+
+// pick is acquire without waiting; when no server can take the render, it
+// returns how long until a breaker lets a trial through.
+func (p *serverPool) pick(avoid string) (*serverState, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s := p.halfOpen(); s != nil {
+		s.trial = true
+		s.inFlight++
+		p.logger.Printf("breaker of server %s half-open after %s, sending a trial render", s.conf.name(), p.cooldown)
+		return s, 0
+	}
+
+	var s *serverState
+	if p.policy == balanceLatency {
+		s = p.weighted(avoid)
+	}
+	for i := 0; i < len(p.servers) && p.policy != balanceLatency; i++ {
+		c := p.servers[(p.next+i)%len(p.servers)]
+		if !p.eligible(c, avoid) {
+			continue
+		}
+		if s == nil {
+			s = c
+			if p.policy == balanceRoundRobin {
+				break
+			}
+		} else if c.inFlight < s.inFlight {
+			// ties go round-robin so idle servers share the load
+			s = c
+		}
+	}
+	p.next++
+	if s == nil {
+		return nil, p.untilTrial()
+	}
+	s.inFlight++
+	return s, 0
+}
+
+// halfOpen returns a server whose breaker has been open for the cooldown
+// and has no trial in flight.
+func (p *serverPool) halfOpen() *serverState {
+	if p.cooldown <= 0 {
+		return nil
+	}
+	for _, s := range p.servers {
+		if s.unhealthy && !s.trial && time.Since(s.openedAt) >= p.cooldown {
+			return s
+		}
+	}
+	return nil
+}
+
+// untilTrial returns how long until the next breaker turns half-open, or a
+// second while trials are in flight; 0 without breakers.
+func (p *serverPool) untilTrial() time.Duration {
+	if p.cooldown <= 0 {
+		return 0
+	}
+	next := time.Duration(0)
+	for _, s := range p.servers {
+		if !s.unhealthy {
+			continue
+		}
+		in := time.Second
+		if !s.trial {
+			in = p.cooldown - time.Since(s.openedAt)
+		}
+		if in < time.Millisecond {
+			in = time.Millisecond
+		}
+		if next == 0 || in < next {
+			next = in
+		}
+	}
+	return next
+}
+
+// weighted picks the server of the smooth weighted round-robin: every
+// server gains its weight, and the one ahead is picked and set back by the
+// total, which spreads the picks of each server evenly.
+func (p *serverPool) weighted(avoid string) *serverState {
+	var s *serverState
+	total := 0.0
+	for _, c := range p.servers {
+		if !p.eligible(c, avoid) {
+			continue
+		}
+		w := c.weight()
+		c.current += w
+		total += w
+		if s == nil || c.current > s.current {
+			s = c
+		}
+	}
+	if s != nil {
+		s.current -= total
+	}
+	return s
+}
+
+func (p *serverPool) eligible(s *serverState, avoid string) bool {
+	return !s.unhealthy && (s.conf.name() != avoid || p.healthy() == 1)
+}
+
+func (p *serverPool) release(s *serverState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.inFlight--
+	// a trial that never reached the server is tried again
+	s.trial = false
+}
+
+// report records the outcome of a render on s. Network errors and server
+// errors count against the server; anything else means it is up. Once
+// maxFailures are reached the server is pinged, so a run of pages that fail
+// to render doesn't take a server that is up out of rotation.
+func (p *serverPool) report(s *serverState, statusCode int, err error) {
+	if p.cooldown > 0 {
+		p.trip(s, err == nil || (statusCode > 0 && statusCode < 500))
+		return
+	}
+
+	p.mu.Lock()
+	if err == nil || (statusCode > 0 && statusCode < 500) {
+		s.failures = 0
+		p.mu.Unlock()
+		return
+	}
+	s.failures++
+	suspect := !s.unhealthy && p.maxFailures > 0 && s.failures >= p.maxFailures
+	p.mu.Unlock()
+
+	if !suspect {
+		return
+	}
+	pingErr := ping(s.conf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pingErr == nil {
+		s.failures = 0
+		return
+	}
+	if !s.unhealthy {
+		s.unhealthy = true
+		p.logger.Printf("taking server %s out of rotation after %d failed renders (%v), %d healthy left", s.conf.name(), s.failures, pingErr, p.healthy())
+	}
+}
+
+// trip records the outcome of a render on s for its breaker.
+func (p *serverPool) trip(s *serverState, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	trial := s.trial
+	s.trial = false
+	switch {
+	case ok && trial:
+		s.unhealthy, s.failures = false, 0
+		p.logger.Printf("breaker of server %s closed, the trial render succeeded; %d healthy", s.conf.name(), p.healthy())
+	case ok:
+		s.failures = 0
+	case trial:
+		s.openedAt = time.Now()
+		p.logger.Printf("breaker of server %s open again, the trial render failed", s.conf.name())
+	case !s.unhealthy:
+		if s.failures++; s.failures >= p.maxFailures {
+			s.unhealthy, s.openedAt = true, time.Now()
+			p.logger.Printf("breaker of server %s open after %d failed renders, %d healthy left", s.conf.name(), s.failures, p.healthy())
+		}
+	}
+}
+
+// available returns the servers currently in rotation.
+func (p *serverPool) available() []serverConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var list []serverConfig
+	for _, s := range p.servers {
+		if !s.unhealthy {
+			list = append(list, s.conf)
+		}
+	}
+	return list
+}
+
+func (p *serverPool) healthy() int {
+	n := 0
+	for _, s := range p.servers {
+		if !s.unhealthy {
+			n++
+		}
+	}
+	return n
+}
+
+func ping(s serverConfig) error {
+	if s.Protocol == protocolGRPC {
+		return pingGRPC(s)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(s.url(s.PingPath))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("ping returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pingAll pings servers in parallel, returning the error and round-trip
+// time of each.
+func pingAll(servers []*serverState) ([]error, []time.Duration) {
+	errs, rtts := make([]error, len(servers)), make([]time.Duration, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, conf serverConfig) {
+			defer wg.Done()
+			start := time.Now()
+			errs[i] = ping(conf)
+			rtts[i] = time.Since(start)
+		}(i, s.conf)
+	}
+	wg.Wait()
+	return errs, rtts
+}
+
+// checkAll pings every server, taking the ones that don't answer out of
+// rotation. It fails when none of them is available.
+func (p *serverPool) checkAll() error {
+	errs, rtts := pingAll(p.servers)
+	for i, s := range p.servers {
+		if errs[i] != nil {
+			p.logger.Printf("server %s is not available: %v", s.conf.name(), errs[i])
+			s.unhealthy, s.openedAt = true, time.Now()
+			continue
+		}
+		s.measured(rtts[i])
+		p.logger.Printf("screenshot taker server %s is available (ping %s)", s.conf.name(), rtts[i].Round(time.Millisecond))
+	}
+	if p.healthy() == 0 {
+		return errNoServers
+	}
+	if p.policy == balanceLatency && len(p.servers) > 1 {
+		p.logger.Printf("balancing by latency: %s", p.shares())
+	}
+	return nil
+}
+
+// shares describes the share of renders balanceLatency gives each healthy
+// server.
+func (p *serverPool) shares() string {
+	total := 0.0
+	for _, s := range p.servers {
+		if !s.unhealthy {
+			total += s.weight()
+		}
+	}
+	var shares []string
+	for _, s := range p.servers {
+		if !s.unhealthy {
+			shares = append(shares, fmt.Sprintf("%s %.0f%%", s.conf.name(), 100*s.weight()/total))
+		}
+	}
+	return strings.Join(shares, ", ")
+}
+
+// probe pings the unhealthy servers every interval and puts the ones that
+// answer back into rotation, until stop is called. With balanceLatency the
+// healthy servers are pinged too, to follow their latency. Breakers are only
+// closed by their trial renders.
+func (p *serverPool) probe(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	p.stopProbes = make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopProbes:
+				return
+			case <-ticker.C:
+			}
+
+			p.mu.Lock()
+			var pinged []*serverState
+			for _, s := range p.servers {
+				if (s.unhealthy && p.cooldown <= 0) || p.policy == balanceLatency {
+					pinged = append(pinged, s)
+				}
+			}
+			p.mu.Unlock()
+
+			errs, rtts := pingAll(pinged)
+			p.mu.Lock()
+			for i, s := range pinged {
+				if errs[i] != nil {
+					continue
+				}
+				s.measured(rtts[i])
+				if s.unhealthy && p.cooldown <= 0 {
+					s.unhealthy, s.failures = false, 0
+					p.logger.Printf("server %s is back, %d healthy", s.conf.name(), p.healthy())
+				}
+			}
+			p.mu.Unlock()
+		}
+	}()
+}
+
+func (p *serverPool) stop() {
+	if p == nil {
+		return
+	}
+	if p.stopProbes != nil {
+		close(p.stopProbes)
+	}
+	for _, s := range p.servers {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}
+}
+
+// multiple reports whether results should name the server they came from.
+func (p *serverPool) multiple() bool {
+	return p != nil && len(p.servers) > 1
+}
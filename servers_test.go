@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+	a := &serverState{conf: serverConfig{Host: "a", Port: 1}}
+	b := &serverState{conf: serverConfig{Host: "b", Port: 1}}
+	p := &serverPool{policy: balanceRoundRobin, servers: []*serverState{a, b}, maxFailures: 2, cooldown: cooldown, logger: log.New(io.Discard, "", 0)}
+	down := errors.New("connection refused")
+
+	render := func(s *serverState, statusCode int, err error) {
+		t.Helper()
+		p.report(s, statusCode, err)
+		p.release(s)
+	}
+	pick := func() *serverState {
+		t.Helper()
+		s, _ := p.pick("")
+		return s
+	}
+
+	// a failure that isn't the server's doesn't count
+	render(a, 503, down)
+	render(a, 404, down)
+	render(a, 503, down)
+	if a.unhealthy {
+		t.Fatal("breaker of a open after failures that weren't in a row")
+	}
+	render(a, 0, down)
+	if !a.unhealthy {
+		t.Fatal("breaker of a closed after 2 failures in a row")
+	}
+	for i := 0; i < 2; i++ {
+		if s := pick(); s != b {
+			t.Fatalf("picked %v while the breaker of a is open", serverName(s))
+		}
+		render(b, 0, down)
+	}
+	if !b.unhealthy {
+		t.Fatal("breaker of b closed")
+	}
+
+	// both open: no server until the cooldown is over
+	s, retryIn := p.pick("")
+	if s != nil || retryIn <= 0 || retryIn > cooldown {
+		t.Fatalf("picked %v, retry in %s, with both breakers open", serverName(s), retryIn)
+	}
+	if _, err := p.acquire(""); err != errNoServers {
+		t.Fatalf("acquire = %v, want %v", err, errNoServers)
+	}
+
+	// half-open: one trial for each, and the trial of a fails
+	time.Sleep(cooldown)
+	trialA := pick()
+	if trialA != a || !a.trial {
+		t.Fatalf("first trial went to %v", serverName(trialA))
+	}
+	trialB := pick()
+	if trialB != b {
+		t.Fatalf("second trial went to %v", serverName(trialB))
+	}
+	if s, retryIn := p.pick(""); s != nil || retryIn != time.Second {
+		t.Fatalf("picked %v, retry in %s, with both trials in flight", serverName(s), retryIn)
+	}
+	render(a, 0, down)
+	if !a.unhealthy || a.trial || time.Since(a.openedAt) >= cooldown {
+		t.Fatal("breaker of a didn't open again for a cooldown after its trial failed")
+	}
+
+	// a trial that never reached the server is sent again
+	p.release(b)
+	if s := pick(); s != b {
+		t.Fatalf("trial of b not sent again: picked %v", serverName(s))
+	}
+	render(b, 200, nil)
+	if b.unhealthy || b.failures != 0 {
+		t.Fatal("breaker of b still open after its trial succeeded")
+	}
+	if s := pick(); s != b {
+		t.Fatalf("picked %v, want b with the breaker of a open", serverName(s))
+	}
+	p.release(b)
+
+	time.Sleep(cooldown)
+	if s := pick(); s != a {
+		t.Fatalf("no second trial of a: picked %v", serverName(s))
+	}
+	render(a, 404, down)
+	if a.unhealthy || p.healthy() != 2 {
+		t.Fatal("breaker of a still open after a trial the page failed")
+	}
+}
+
+func serverName(s *serverState) string {
+	if s == nil {
+		return "none"
+	}
+	return s.conf.name()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// shard is the slice of the input a machine captures with -shard i/n: the
+// URLs whose hash falls in the i-th of n buckets. Hashing the URL rather
+// than counting lines keeps the slices apart however each machine reads the
+// input, and sends a URL listed twice to the same machine.
+type shard struct {
+	index int
+	count int
+}
+
+func parseShard(s string) (*shard, error) {
+	if s == "" {
+		return nil, nil
+	}
+	i, n, ok := strings.Cut(s, "/")
+	index, err := strconv.Atoi(strings.TrimSpace(i))
+	if err != nil || !ok {
+		return nil, fmt.Errorf("%q is not i/n", s)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not i/n", s)
+	}
+	if count < 1 || index < 1 || index > count {
+		return nil, fmt.Errorf("%q: i must be between 1 and n", s)
+	}
+	return &shard{index: index, count: count}, nil
+}
+
+func (s *shard) String() string {
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+func (s *shard) owns(u string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(u))
+	return int(h.Sum64()%uint64(s.count)) == s.index-1
+}
+
+// shardSource passes on the jobs of its shard. Errors that stop the input
+// are passed on by every shard, the invalid lines by the one owning them.
+type shardSource struct {
+	jobs  jobSource
+	shard *shard
+	// skipped counts the URLs of other shards.
+	skipped int
+}
+
+func (s *shardSource) next() (job, error) {
+	for {
+		j, err := s.jobs.next()
+		if j.url == "" || s.shard.owns(j.url) {
+			return j, err
+		}
+		s.skipped++
+	}
+}
+
+func (s *shardSource) Close() error {
+	if closer, ok := s.jobs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
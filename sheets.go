@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets.readonly"
+
+// openSheet reads jobs from sheets://<spreadsheet id>[/<range>], e.g.
+// sheets://1AbC.../Inventory!A:D. The first row names the columns as for any
+// table input. Public sheets can be read with GOOGLE_API_KEY; otherwise
+// application default credentials are used.
+func openSheet(ctx context.Context, name string) (*tableReader, error) {
+	id, rng, _ := strings.Cut(strings.TrimPrefix(name, "sheets://"), "/")
+	if id == "" {
+		return nil, fmt.Errorf("%s must look like sheets://<spreadsheet id>/<range>", name)
+	}
+	if rng == "" {
+		rng = "A:Z"
+	}
+
+	endpoint := fmt.Sprintf("https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s", url.PathEscape(id), url.PathEscape(rng))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.DefaultClient
+	if key := os.Getenv("GOOGLE_API_KEY"); key != "" {
+		req.Header.Set("X-Goog-Api-Key", key)
+	} else if client, err = google.DefaultClient(ctx, sheetsScope); err != nil {
+		return nil, fmt.Errorf("can't get Google credentials (set GOOGLE_API_KEY for public sheets): %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return nil, fmt.Errorf("sheets API returned %s", resp.Status)
+	}
+
+	var values struct {
+		Values [][]string `json:"values"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("can't decode sheets API response: %w", err)
+	}
+
+	rows := sliceRows(values.Values)
+	return newTableReader(&rows)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// shot is one image taken of a URL. Most URLs get a single shot; options
+// such as -capturePositions take several, which share the file base name.
+type shot struct {
+	base          string
+	scrollPercent int
+	// frameSelector selects the iframe to capture instead of the page;
+	// frameIndex is its position in -frameSelector, used in the file name.
+	frameSelector string
+	frameIndex    int
+	// env is set with -compareEnvironments.
+	env *environment
+}
+
+// suffix distinguishes the files of the shots of one URL.
+func (s shot) suffix() string {
+	var suffix string
+	if s.env != nil {
+		suffix += "-" + s.env.Name
+	}
+	if s.frameSelector != "" {
+		suffix += fmt.Sprintf("-frame%d", s.frameIndex+1)
+	}
+	if s.scrollPercent >= 0 {
+		suffix += fmt.Sprintf("-scroll%d", s.scrollPercent)
+	}
+	return suffix
+}
+
+// parsePositions turns "0%,50%,100%" into scroll offsets in percent.
+func parsePositions(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var positions []int
+	for _, p := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(p), "%"))
+		if err != nil || n < 0 || n > 100 {
+			return nil, fmt.Errorf("invalid capture position %q (use 0%%-100%%)", p)
+		}
+		positions = append(positions, n)
+	}
+	return positions, nil
+}
+
+// shots returns the images to take of a URL whose files are named after base:
+// one per frame selector (or the page itself) and scroll position, each in
+// every environment with -compareEnvironments.
+func (runOptions *runOptions) shots(base string) []shot {
+	positions := runOptions.positions
+	if len(positions) == 0 {
+		positions = []int{-1}
+	}
+	frames := []string(runOptions.frameSelectors)
+	if len(frames) == 0 {
+		frames = []string{""}
+	}
+
+	envs := []*environment{nil}
+	if len(runOptions.environments) > 0 {
+		envs = envs[:0]
+		for i := range runOptions.environments {
+			envs = append(envs, &runOptions.environments[i])
+		}
+	}
+
+	list := make([]shot, 0, len(positions)*len(frames)*len(envs))
+	for i, frame := range frames {
+		for _, p := range positions {
+			for _, env := range envs {
+				list = append(list, shot{base: base, scrollPercent: p, frameSelector: frame, frameIndex: i, env: env})
+			}
+		}
+	}
+	return list
+}
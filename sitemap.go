@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// sitemapSource yields the pages of a sitemap (sitemaps.org). The sitemaps a
+// sitemap index lists are fetched with openInput as they are reached, so a
+// large site is streamed rather than held in memory.
+type sitemapSource struct {
+	ctx  context.Context
+	opts inputOptions
+	name string
+	rc   io.ReadCloser
+	dec  *xml.Decoder
+	// pending are the sitemaps of an index that are still to be read, seen
+	// all the sitemaps queued so far, so that cycles end.
+	pending []string
+	seen    map[string]bool
+	line    int
+}
+
+// newSitemapSource reads the sitemap dec decodes from rc.
+func newSitemapSource(ctx context.Context, name string, rc io.ReadCloser, dec *xml.Decoder, opts inputOptions) *sitemapSource {
+	// the cache only applies to the sitemap of -file
+	opts.cache = nil
+	return &sitemapSource{ctx: ctx, opts: opts, name: name, rc: rc, dec: dec, seen: map[string]bool{name: true}}
+}
+
+func (s *sitemapSource) next() (job, error) {
+	for {
+		if s.dec == nil {
+			if len(s.pending) == 0 {
+				return job{}, io.EOF
+			}
+			s.name, s.pending = s.pending[0], s.pending[1:]
+			rc, err := openInput(s.ctx, s.name, s.opts)
+			if err != nil {
+				// reported against the sitemap, the rest of the index is
+				// still read
+				return job{url: s.name}, fmt.Errorf("sitemap %s: %w", s.name, err)
+			}
+			s.rc, s.dec = rc, xml.NewDecoder(rc)
+		}
+
+		loc, index, err := s.nextLoc()
+		if err == io.EOF {
+			s.rc.Close()
+			s.dec = nil
+			continue
+		}
+		if err != nil {
+			s.rc.Close()
+			s.dec = nil
+			return job{url: s.name}, fmt.Errorf("sitemap %s: %w", s.name, err)
+		}
+
+		if index {
+			if !s.seen[loc] {
+				s.seen[loc] = true
+				s.pending = append(s.pending, loc)
+			}
+			continue
+		}
+
+		s.line++
+		j := job{url: loc, line: s.line}
+		if _, err := url.Parse(loc); err != nil {
+			return j, fmt.Errorf("entry %d: %w", s.line, err)
+		}
+		return j, nil
+	}
+}
+
+// nextLoc returns the next loc of the sitemap being read, and whether it
+// names another sitemap, as the entries of an index do.
+func (s *sitemapSource) nextLoc() (string, bool, error) {
+	for {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return "", false, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || (start.Name.Local != "url" && start.Name.Local != "sitemap") {
+			continue
+		}
+		var entry struct {
+			Loc string `xml:"loc"`
+		}
+		if err = s.dec.DecodeElement(&entry, &start); err != nil {
+			return "", false, err
+		}
+		if loc := strings.TrimSpace(entry.Loc); loc != "" {
+			return loc, start.Name.Local == "sitemap", nil
+		}
+	}
+}
+
+func (s *sitemapSource) Close() error {
+	if s.dec == nil {
+		return nil
+	}
+	return s.rc.Close()
+}
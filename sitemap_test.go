@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSitemapIndex(t *testing.T) {
+	src, err := openJobs(context.Background(), "testdata/sitemap-index.xml", inputOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer, ok := src.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	// the index lists its sitemaps in order, pages twice, and posts is an
+	// index listing the first index again
+	want := []struct {
+		url  string
+		line int
+		err  string
+	}{
+		{"https://example.com/", 1, ""},
+		{"https://example.com/about", 2, ""},
+		{"testdata/sitemap-missing.xml", 0, "sitemap testdata/sitemap-missing.xml"},
+		{"https://example.com/2019/hello", 3, ""},
+	}
+	for i, w := range want {
+		j, err := src.next()
+		if err == io.EOF {
+			t.Fatalf("entry %d: unexpected end, want %s", i, w.url)
+		}
+		if j.url != w.url || j.line != w.line {
+			t.Errorf("entry %d = %s line %d, want %s line %d", i, j.url, j.line, w.url, w.line)
+		}
+		if (err == nil) != (w.err == "") || (err != nil && !strings.Contains(err.Error(), w.err)) {
+			t.Errorf("entry %d: error %v, want %q", i, err, w.err)
+		}
+	}
+	if j, err := src.next(); err != io.EOF {
+		t.Errorf("got %s (%v) after the last entry, want the end", j.url, err)
+	}
+}
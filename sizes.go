@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// minSizeSamples is the number of saved captures of a domain and format
+// below which its sizes aren't judged.
+const minSizeSamples = 5
+
+// domainSizes summarizes the image sizes of the saved captures of a domain
+// in one format.
+type domainSizes struct {
+	Domain      string `json:"domain"`
+	Format      string `json:"format"`
+	Captures    int    `json:"captures"`
+	MinBytes    int64  `json:"minBytes"`
+	MedianBytes int64  `json:"medianBytes"`
+	MaxBytes    int64  `json:"maxBytes"`
+}
+
+// flagSizeAnomalies records the size distribution of the saved captures per
+// domain and format, and flags the captures more than factor times smaller
+// or larger than the median of theirs. Those are most often error pages,
+// blank renders or pages that fell back to a different layout. It returns
+// the flagged results.
+func (r *runReport) flagSizeAnomalies(factor float64) []*captureResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct{ domain, format string }
+	groups := map[key][]*captureResult{}
+	for _, res := range r.Results {
+		if res.Status == statusSaved && res.Bytes > 0 {
+			k := key{hostOf(res.URL), extension(res.FileName)}
+			groups[k] = append(groups[k], res)
+		}
+	}
+
+	keys := make([]key, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].domain != keys[j].domain {
+			return keys[i].domain < keys[j].domain
+		}
+		return keys[i].format < keys[j].format
+	})
+
+	r.Sizes = nil
+	var flagged []*captureResult
+	for _, k := range keys {
+		group := groups[k]
+		sort.Slice(group, func(i, j int) bool { return group[i].Bytes < group[j].Bytes })
+		median := group[len(group)/2].Bytes
+		r.Sizes = append(r.Sizes, domainSizes{
+			Domain:      k.domain,
+			Format:      k.format,
+			Captures:    len(group),
+			MinBytes:    group[0].Bytes,
+			MedianBytes: median,
+			MaxBytes:    group[len(group)-1].Bytes,
+		})
+
+		if factor <= 0 || len(group) < minSizeSamples {
+			continue
+		}
+		for _, res := range group {
+			ratio := float64(res.Bytes) / float64(median)
+			switch {
+			case ratio*factor <= 1:
+				res.SizeAnomaly = fmt.Sprintf("%.0fx smaller than the median %s of its domain", 1/ratio, k.format)
+			case ratio >= factor:
+				res.SizeAnomaly = fmt.Sprintf("%.0fx larger than the median %s of its domain", ratio, k.format)
+			default:
+				continue
+			}
+			flagged = append(flagged, res)
+		}
+	}
+	r.SizeAnomalies = len(flagged)
+	return flagged
+}
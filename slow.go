@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// errTooSlow fails a render cancelled by -slowRetry.
+var errTooSlow = errors.New("render too slow")
+
+// slowWatchdog flags captures that take longer than threshold (-slowThreshold)
+// while they are still running, so a few pathological pages can be spotted
+// before the run ends. A nil watchdog is off.
+type slowWatchdog struct {
+	threshold time.Duration
+	// webhook receives a slowEvent for each slow capture.
+	webhook string
+	// retry cancels renders at the threshold and renders them once more on
+	// another server.
+	retry bool
+}
+
+// slowEvent is posted to -slowWebhook.
+type slowEvent struct {
+	RunID     string `json:"runId"`
+	CaptureID string `json:"captureId"`
+	URL       string `json:"url"`
+	ElapsedMs int64  `json:"elapsedMs"`
+}
+
+// watch starts timing res and returns the function stopping it, which marks
+// res slow when it took longer than the threshold.
+func (w *slowWatchdog) watch(runOptions *runOptions, res *captureResult, logger *log.Logger) func() {
+	if w == nil {
+		return func() {}
+	}
+
+	u, id := res.URL, res.CaptureID
+	timer := time.AfterFunc(w.threshold, func() {
+		logger.Printf("warning: %s (capture %s) is still capturing after %s", runOptions.value(u), id, w.threshold)
+		if w.webhook == "" {
+			return
+		}
+		body, _ := json.Marshal(slowEvent{RunID: runOptions.runID, CaptureID: id, URL: runOptions.value(u), ElapsedMs: w.threshold.Milliseconds()})
+		if err := postWebhook(w.webhook, os.Getenv("SCREENSHOTER_WEBHOOK_SECRET"), body, *hookRetries, logger); err != nil {
+			logger.Printf("slow capture webhook %s failed: %v", w.webhook, err)
+		}
+	})
+	return func() {
+		timer.Stop()
+		res.Slow = time.Since(res.StartedAt) > w.threshold
+	}
+}
+
+// serverChoice is carried by the context of a render: avoid names a server
+// not to render on when another one is healthy, and render records the
+// server it used.
+type serverChoice struct {
+	avoid string
+	used  string
+}
+
+type serverChoiceKey struct{}
+
+func withServerChoice(ctx context.Context, choice *serverChoice) context.Context {
+	return context.WithValue(ctx, serverChoiceKey{}, choice)
+}
+
+func serverChoiceFrom(ctx context.Context) *serverChoice {
+	choice, _ := ctx.Value(serverChoiceKey{}).(*serverChoice)
+	if choice == nil {
+		return &serverChoice{}
+	}
+	return choice
+}
+
+// renderBounded renders with the -slowRetry deadline: a render still running
+// at the threshold is cancelled and repeated once on another server, without
+// a deadline so that it finishes however slow the page is.
+func renderBounded(parent context.Context, runOptions *runOptions, u, fileName, captureID string, opts captureOptions, logger *log.Logger) (*rendered, error) {
+	w := runOptions.slow
+	if w == nil || !w.retry || runOptions.browser != nil {
+		return renderThroughProxy(parent, runOptions, u, fileName, captureID, opts, logger)
+	}
+
+	choice := &serverChoice{}
+	ctx, cancel := context.WithTimeout(withServerChoice(parent, choice), w.threshold)
+	out, err := renderThroughProxy(ctx, runOptions, u, fileName, captureID, opts, logger)
+	cancel()
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, err
+	}
+
+	if !runOptions.guard.spendRetry() {
+		return out, errTooSlow
+	}
+	logger.Printf("cancelled %s (capture %s) after %s, rendering it again on another server", runOptions.value(u), captureID, w.threshold)
+	return renderThroughProxy(withServerChoice(parent, &serverChoice{avoid: choice.used}), runOptions, u, fileName, captureID, opts, logger)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// errInputUnchanged is returned for an http(s) list that the server reports
+// as not modified since the run recorded in the input cache.
+var errInputUnchanged = errors.New("input has not changed")
+
+// inputOptions controls how an http(s) input list is fetched.
+type inputOptions struct {
+	// headers are "Name: value" pairs; values may reference environment
+	// variables as $NAME so secrets stay off the command line.
+	headers []string
+	cache   *inputCache
+	// follow keeps reading a local list as lines are appended, until the
+	// context is done.
+	follow bool
+	// xml reads the input as a sitemap or feed whatever its name.
+	xml bool
+	// feedItems is how many of the newest items of a feed are captured, 0
+	// for all of them.
+	feedItems int
+}
+
+// inputCache remembers the validators of the last fetched http(s) list.
+type inputCache struct {
+	path         string
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func loadInputCache(path string) (*inputCache, error) {
+	c := &inputCache{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *inputCache) save() error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// openJobs opens the jobSource named by -file: a sheets:// spreadsheet, or a
+// CSV table (named .csv), JSON Lines input (named .jsonl or .ndjson), sitemap
+// or RSS or Atom feed (named .xml, .rss or .atom) or plain URL list opened
+// with openInput, or followed when it is a local file.
+func openJobs(ctx context.Context, name string, opts inputOptions) (jobSource, error) {
+	if strings.HasPrefix(name, "sheets://") {
+		return openSheet(ctx, name)
+	}
+
+	kind := inputKind(name)
+	if opts.xml || kind == ".rss" || kind == ".atom" {
+		kind = ".xml"
+	}
+
+	var rc io.ReadCloser
+	var err error
+	if opts.follow {
+		if strings.Contains(name, "://") || strings.HasSuffix(name, ".gz") {
+			return nil, fmt.Errorf("-follow needs an uncompressed local file")
+		}
+		if kind == ".xml" {
+			return nil, fmt.Errorf("-follow doesn't apply to sitemaps and feeds")
+		}
+		rc, err = openFollow(ctx, name)
+	} else {
+		rc, err = openInput(ctx, name, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case ".xml":
+		return openXML(ctx, name, rc, opts)
+	case ".jsonl", ".ndjson":
+		return jsonlSource{jsonlReader: newJSONLReader(rc), Closer: rc}, nil
+	case ".csv":
+		r := csv.NewReader(rc)
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		tr, err := newTableReader(r)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return tableSource{tableReader: tr, Closer: rc}, nil
+	default:
+		return streamSource{lineReader: newLineReader(rc), Closer: rc}, nil
+	}
+}
+
+// inputKind returns the extension of an input, without a .gz suffix.
+func inputKind(name string) string {
+	return path.Ext(strings.TrimSuffix(strings.ToLower(listPath(name)), ".gz"))
+}
+
+// listPath returns the path of a remote input without its query, and a
+// local one as it is: a file name may hold characters such as % that don't
+// parse as a URL.
+func listPath(name string) string {
+	if !strings.Contains(name, "://") {
+		return name
+	}
+	if u, err := url.Parse(name); err == nil {
+		return u.Path
+	}
+	return name
+}
+
+// openInput opens the URL list named by -file: a local path, an http(s) URL,
+// s3://bucket/key or gs://bucket/object. Lists ending in .gz are decompressed
+// on the fly. Credentials come from the SDKs' default chains (AWS_* variables,
+// shared config, GOOGLE_APPLICATION_CREDENTIALS, instance metadata).
+func openInput(ctx context.Context, name string, opts inputOptions) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	var err error
+
+	switch {
+	case strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://"):
+		rc, err = openHTTPInput(ctx, name, opts)
+	case strings.HasPrefix(name, "s3://"):
+		rc, err = openS3Object(ctx, name)
+	case strings.HasPrefix(name, "gs://"):
+		rc, err = openGCSObject(ctx, name)
+	default:
+		rc, err = os.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(listPath(name), ".gz") {
+		return rc, nil
+	}
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("can't decompress %s: %w", name, err)
+	}
+	return &gzipInput{Reader: gz, under: rc}, nil
+}
+
+// bucketObject splits scheme://bucket/key.
+func bucketObject(name string) (string, string, error) {
+	u, err := url.Parse(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("%s must look like %s://bucket/key", name, u.Scheme)
+	}
+	return u.Host, key, nil
+}
+
+// inputClient fetches http(s) inputs. A list is read as the run goes, for
+// as long as it takes, so only connecting and the response headers are
+// timed out; inputIdle bounds the reads of the body.
+var inputClient = &http.Client{Transport: &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+}}
+
+// inputIdle is how long a read of an http(s) input may wait for data, so a
+// server that stalls mid-list can't hang the run.
+var inputIdle = 5 * time.Minute
+
+func openHTTPInput(ctx context.Context, name string, opts inputOptions) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range opts.headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("input header %q must look like Name: value", h)
+		}
+		req.Header.Set(strings.TrimSpace(k), os.ExpandEnv(strings.TrimSpace(v)))
+	}
+
+	cache := opts.cache
+	if cache != nil && cache.URL == name {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := inputClient.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		cancel()
+		return nil, errInputUnchanged
+	}
+	if resp.StatusCode > 299 {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	if cache != nil {
+		cache.URL = name
+		cache.ETag = resp.Header.Get("ETag")
+		cache.LastModified = resp.Header.Get("Last-Modified")
+	}
+	return newIdleBody(resp.Body, inputIdle, cancel), nil
+}
+
+// idleBody fails a read of body that waits more than idle for data, by
+// cancelling its request. Only the time spent in Read counts, so a run
+// that is slow to take the next URL doesn't time the list out.
+type idleBody struct {
+	body    io.ReadCloser
+	idle    time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	stalled atomic.Bool
+}
+
+func newIdleBody(body io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *idleBody {
+	b := &idleBody{body: body, idle: idle, cancel: cancel}
+	b.timer = time.AfterFunc(idle, func() {
+		b.stalled.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.idle)
+	n, err := b.body.Read(p)
+	b.timer.Stop()
+	if err != nil && b.stalled.Load() {
+		err = fmt.Errorf("input sent nothing for %s", b.idle)
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+func openS3Object(ctx context.Context, name string) (io.ReadCloser, error) {
+	bucket, key, err := bucketObject(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't load AWS configuration: %w", err)
+	}
+
+	out, err := s3.NewFromConfig(cfg).GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func openGCSObject(ctx context.Context, name string) (io.ReadCloser, error) {
+	bucket, object, err := bucketObject(name)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gcs.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("can't create GCS client: %w", err)
+	}
+
+	r, err := client.Bucket(bucket).Object(object).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &gcsInput{Reader: r, client: client}, nil
+}
+
+type gzipInput struct {
+	*gzip.Reader
+	under io.Closer
+}
+
+func (g *gzipInput) Close() error {
+	g.Reader.Close()
+	return g.under.Close()
+}
+
+type gcsInput struct {
+	*gcs.Reader
+	client *gcs.Client
+}
+
+func (g *gcsInput) Close() error {
+	g.Reader.Close()
+	return g.client.Close()
+}
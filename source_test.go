@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPListIdle(t *testing.T) {
+	defer func(idle time.Duration) { inputIdle = idle }(inputIdle)
+	inputIdle = 100 * time.Millisecond
+
+	// the list trickles in over longer than inputIdle; /stall.txt then
+	// hangs without ending
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 8; i++ {
+			fmt.Fprintf(w, "https://example.com/%d\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(30 * time.Millisecond):
+			}
+		}
+		if r.URL.Path == "/stall.txt" {
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		// pause is how long the run takes over each URL
+		pause time.Duration
+		err   string
+	}{
+		{"/list.txt", 0, ""},
+		{"/list.txt", 150 * time.Millisecond, ""},
+		{"/stall.txt", 0, "input sent nothing for 100ms"},
+	}
+	for _, tt := range tests {
+		src, err := openJobs(context.Background(), srv.URL+tt.path, inputOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		for {
+			j, err := src.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if tt.err == "" || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("%s after %d URLs: %v, want %q", tt.path, n, err, tt.err)
+				}
+				break
+			}
+			if want := fmt.Sprintf("https://example.com/%d", n); j.url != want {
+				t.Errorf("%s: URL %d = %s, want %s", tt.path, n, j.url, want)
+			}
+			n++
+			time.Sleep(tt.pause)
+		}
+		if n != 8 {
+			t.Errorf("%s: read %d URLs, want 8", tt.path, n)
+		}
+		if closer, ok := src.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
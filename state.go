@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateVersion is the version of the state files export writes; import
+// refuses the ones it doesn't know.
+const stateVersion = 1
+
+// runState is a portable state file: the -stateFile checkpoint of a partly
+// done run, the baseline manifest if there is one and a fingerprint of the
+// config.yaml it ran with, so the run can be resumed on another machine.
+type runState struct {
+	Version     int               `json:"version"`
+	ExportedAt  time.Time         `json:"exportedAt"`
+	Checkpoint  []checkpointEntry `json:"checkpoint"`
+	Manifest    json.RawMessage   `json:"manifest,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+}
+
+// configFingerprint hashes config.yaml, or returns "" when there is none.
+func configFingerprint(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// readCheckpoint returns the entries of a -stateFile, leaving out a line cut
+// short by a crash like resuming does.
+func readCheckpoint(path string) ([]checkpointEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	entries := []checkpointEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e checkpointEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// runExport implements `screenshoter export [flags] state.json`.
+func runExport(c *command, args []string) error {
+	fs := c.flagSet(false)
+	statePath := fs.String("stateFile", "", "Checkpoint of the run to export (required)")
+	baseline := fs.String("baselineDir", "baselines", "Directory whose manifest is exported along, if it has one")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *statePath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	s := &runState{Version: stateVersion, ExportedAt: time.Now()}
+	var err error
+	if s.Checkpoint, err = readCheckpoint(*statePath); err != nil {
+		return err
+	}
+	if s.Fingerprint, err = configFingerprint("config.yaml"); err != nil {
+		return err
+	}
+	manifest, err := os.ReadFile(filepath.Join(*baseline, manifestName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if !json.Valid(manifest) {
+			return fmt.Errorf("%s is not valid JSON", filepath.Join(*baseline, manifestName))
+		}
+		s.Manifest = manifest
+	}
+
+	data, err := marshalIndented(s)
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(fs.Arg(0), data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d finished URLs to %s\n", len(s.Checkpoint), fs.Arg(0))
+	return nil
+}
+
+// runImport implements `screenshoter import [flags] state.json`: it writes
+// the checkpoint and manifest of an exported run back, for `capture -resume`
+// to carry on with.
+func runImport(c *command, args []string) error {
+	fs := c.flagSet(false)
+	statePath := fs.String("stateFile", "", "Checkpoint to write, for -resume (required)")
+	baseline := fs.String("baselineDir", "baselines", "Directory to write the exported manifest to")
+	force := fs.Bool("force", false, "Overwrite an existing -stateFile and manifest, and import even though config.yaml differs from the exporter's")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *statePath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var s runState
+	if err = json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%s is not a state file: %w", fs.Arg(0), err)
+	}
+	if s.Version != stateVersion {
+		return fmt.Errorf("%s has state version %d, this screenshoter reads %d", fs.Arg(0), s.Version, stateVersion)
+	}
+
+	fingerprint, err := configFingerprint("config.yaml")
+	if err != nil {
+		return err
+	}
+	if fingerprint != s.Fingerprint {
+		if !*force {
+			return fmt.Errorf("config.yaml differs from the one the run was exported with; resuming would capture the rest with other settings (-force to import anyway)")
+		}
+		fmt.Fprintln(os.Stderr, "warning: config.yaml differs from the one the run was exported with")
+	}
+
+	manifest := filepath.Join(*baseline, manifestName)
+	targets := []string{*statePath}
+	if s.Manifest != nil {
+		targets = append(targets, manifest)
+	}
+	for _, path := range targets {
+		if _, err := os.Stat(path); err == nil && !*force {
+			return fmt.Errorf("%s already exists (-force to overwrite it)", path)
+		}
+	}
+
+	var lines []byte
+	for _, e := range s.Checkpoint {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err = os.WriteFile(*statePath, lines, 0644); err != nil {
+		return err
+	}
+	if s.Manifest != nil {
+		if err = os.MkdirAll(*baseline, 0755); err != nil {
+			return err
+		}
+		if err = os.WriteFile(manifest, s.Manifest, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("imported %d finished URLs into %s; run capture -resume -stateFile %s to carry on\n", len(s.Checkpoint), *statePath, *statePath)
+	return nil
+}
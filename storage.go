@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectsDir holds the content-addressed images of a deduplicated directory.
+const objectsDir = ".objects"
+
+// storage is a destination for finished screenshots. Put stores the content
+// of r under name and returns where it ended up (a path or URL). Backends that
+// hold resources also implement io.Closer and are closed when the run ends.
+//
+// Object stores apply metadata: "content-type" and "cache-control" set the
+// matching headers and every other entry, such as "source-url" and "run-id",
+// becomes custom object metadata.
+type storage interface {
+	Put(ctx context.Context, name string, r io.Reader, metadata map[string]string) (string, error)
+}
+
+// Metadata entries that map to object headers rather than custom metadata.
+const (
+	metaContentType  = "content-type"
+	metaCacheControl = "cache-control"
+)
+
+// customMetadata returns the entries of metadata that aren't headers.
+func customMetadata(metadata map[string]string) map[string]string {
+	custom := map[string]string{}
+	for k, v := range metadata {
+		if k != metaContentType && k != metaCacheControl {
+			custom[k] = v
+		}
+	}
+	return custom
+}
+
+// parseMetadata reads the key=value pairs of -objectMetadata.
+func parseMetadata(pairs []string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		if k = strings.ToLower(strings.TrimSpace(k)); !ok || k == "" {
+			return nil, fmt.Errorf("%q must be key=value", p)
+		}
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// maxSignExpiry is the longest S3 and GCS accept for a signed URL.
+const maxSignExpiry = 7 * 24 * time.Hour
+
+// signer is implemented by backends whose objects can be shared through
+// time-limited, read-only URLs, for reviewers without access to the bucket.
+type signer interface {
+	SignURL(name string, expiry time.Duration) (string, error)
+}
+
+// newStorage picks a backend from the scheme of target. A target without a
+// scheme is a local directory.
+func newStorage(target string, conf *config) (storage, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// no scheme, or a Windows drive letter
+		return &localStorage{dir: target}, nil
+	}
+
+	switch u.Scheme {
+	case "file":
+		return &localStorage{dir: u.Path}, nil
+	case "zip":
+		return newZipStorage(u.Host + u.Path)
+	case "azure":
+		return newAzureStorage(u)
+	case "s3":
+		return newS3Storage(u)
+	case "gs":
+		return newGCSStorage(u)
+	case "sftp":
+		return newSFTPStorage(u, conf.SFTP)
+	case "cloudinary":
+		return newCloudinaryStorage(os.Getenv("CLOUDINARY_URL"), strings.Trim(u.Host+u.Path, "/"))
+	}
+	return nil, fmt.Errorf("unsupported output %q", target)
+}
+
+// localStorage writes screenshots into a directory on disk. With dedup set,
+// every distinct image is kept once under .objects/ and the named files are
+// hard links to it (symlinks where hard links aren't possible).
+type localStorage struct {
+	dir   string
+	dedup bool
+}
+
+func (s *localStorage) Put(_ context.Context, name string, r io.Reader, _ map[string]string) (string, error) {
+	target := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if s.dedup {
+		return target, s.putObject(target, r)
+	}
+
+	// a new file replaces the target rather than truncating it, which may
+	// be a link to an object of an earlier -dedup run
+	f, err := os.CreateTemp(filepath.Dir(target), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err = f.Chmod(0644); err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), target)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return target, nil
+}
+
+// putObject stores r in the content-addressed object directory, keyed by its
+// SHA-256, and links target to it.
+func (s *localStorage) putObject(target string, r io.Reader) error {
+	objects := filepath.Join(s.dir, objectsDir)
+	if err := os.MkdirAll(objects, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(objects, "tmp-*")
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Chmod(0644); err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	object := filepath.Join(objects, sum[:2], sum+filepath.Ext(target))
+	if err = os.MkdirAll(filepath.Dir(object), 0755); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err = os.Stat(object); err == nil {
+		// seen before, in this run or an earlier one
+		os.Remove(tmp.Name())
+	} else if err = os.Rename(tmp.Name(), object); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	os.Remove(target)
+	if err = os.Link(object, target); err == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(filepath.Dir(target), object)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(rel, target)
+}
+
+// enableDedup turns on content-addressed storage for every local directory
+// among the outputs, reporting false when there is none.
+func enableDedup(s storage) bool {
+	switch v := s.(type) {
+	case *localStorage:
+		v.dedup = true
+		return true
+	case *teeStorage:
+		found := false
+		for _, target := range v.targets {
+			found = enableDedup(target) || found
+		}
+		return found
+	}
+	return false
+}
+
+// streamable reports whether s can store an image while it is downloaded:
+// every destination must drop what it got of a download that fails, which
+// an entry written to a zip archive can't be.
+func streamable(s storage) bool {
+	switch v := s.(type) {
+	case *zipStorage:
+		return false
+	case *teeStorage:
+		for _, target := range v.targets {
+			if !streamable(target) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// contentTypes maps image formats to the MIME type stored with the object.
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// objectKey joins a backend prefix and a file name into an object key.
+func objectKey(prefix, name string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// sizeOf returns the length of r when it can be known without reading it,
+// which backends that need a Content-Length up front rely on.
+func sizeOf(r io.Reader) int64 {
+	switch v := r.(type) {
+	case interface{ Len() int }:
+		return int64(v.Len())
+	case *os.File:
+		if stat, err := v.Stat(); err == nil {
+			if pos, err := v.Seek(0, io.SeekCurrent); err == nil {
+				return stat.Size() - pos
+			}
+		}
+	}
+	return -1
+}
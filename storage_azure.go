@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// azureStorage uploads block blobs through the Blob REST API. The target is
+// azure://<account>/<container>/<prefix> and the SAS token is read from
+// AZURE_STORAGE_SAS_TOKEN. Signed URLs need the account key, read from
+// AZURE_STORAGE_ACCOUNT_KEY, since the upload token mustn't be shared.
+type azureStorage struct {
+	account   string
+	container string
+	prefix    string
+	sas       string
+	key       string
+	client    *http.Client
+}
+
+func newAzureStorage(u *url.URL) (*azureStorage, error) {
+	container, prefix, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host == "" || container == "" {
+		return nil, fmt.Errorf("azure output must look like azure://account/container/prefix")
+	}
+
+	sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	if sas == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_SAS_TOKEN is not set")
+	}
+
+	return &azureStorage{
+		account:   u.Host,
+		container: container,
+		prefix:    prefix,
+		sas:       sas,
+		key:       os.Getenv("AZURE_STORAGE_ACCOUNT_KEY"),
+		client:    &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *azureStorage) blobURL(name string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", s.account, s.container, objectKey(s.prefix, name))
+}
+
+// Put uploads r with a single Put Blob when its length is known or it fits
+// one block, and otherwise in blocks of uploadBufferSize committed with Put
+// Block List, so an image of unknown length is never buffered as a whole.
+func (s *azureStorage) Put(ctx context.Context, name string, r io.Reader, metadata map[string]string) (string, error) {
+	blobURL := s.blobURL(name)
+	header := http.Header{}
+	if ct := metadata[metaContentType]; ct != "" {
+		header.Set("x-ms-blob-content-type", ct)
+	}
+	if cc := metadata[metaCacheControl]; cc != "" {
+		header.Set("x-ms-blob-cache-control", cc)
+	}
+	for k, v := range customMetadata(metadata) {
+		// metadata names must be C# identifiers
+		header.Set("x-ms-meta-"+strings.ReplaceAll(k, "-", "_"), v)
+	}
+
+	if size := sizeOf(r); size >= 0 {
+		header.Set("x-ms-blob-type", "BlockBlob")
+		return blobURL, s.send(ctx, blobURL+"?"+s.sas, r, size, header)
+	}
+
+	buf := make([]byte, uploadBufferSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		header.Set("x-ms-blob-type", "BlockBlob")
+		return blobURL, s.send(ctx, blobURL+"?"+s.sas, bytes.NewReader(buf[:n]), int64(n), header)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var list strings.Builder
+	list.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for i := 0; n > 0; i++ {
+		// block IDs must all have the same length
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", i)))
+		target := blobURL + "?comp=block&blockid=" + url.QueryEscape(id) + "&" + s.sas
+		if err = s.send(ctx, target, bytes.NewReader(buf[:n]), int64(n), nil); err != nil {
+			return "", err
+		}
+		list.WriteString("<Latest>" + id + "</Latest>")
+
+		if n, err = io.ReadFull(r, buf); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+	}
+	list.WriteString("</BlockList>")
+
+	header.Set("Content-Type", "application/xml")
+	return blobURL, s.send(ctx, blobURL+"?comp=blocklist&"+s.sas, strings.NewReader(list.String()), int64(list.Len()), header)
+}
+
+// send PUTs body to target.
+func (s *azureStorage) send(ctx context.Context, target string, body io.Reader, size int64, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", target, body)
+	if err != nil {
+		return err
+	}
+
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("azure returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
+
+// sasVersion is the storage service version read-only SAS tokens are signed
+// for; it decides the fields of the string to sign.
+const sasVersion = "2020-12-06"
+
+// SignURL adds a read-only service SAS for the blob to its URL.
+func (s *azureStorage) SignURL(name string, expiry time.Duration) (string, error) {
+	if s.key == "" {
+		return "", fmt.Errorf("AZURE_STORAGE_ACCOUNT_KEY is not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("invalid AZURE_STORAGE_ACCOUNT_KEY: %w", err)
+	}
+
+	expires := time.Now().Add(expiry).UTC().Format(time.RFC3339)
+	resource := fmt.Sprintf("/blob/%s/%s/%s", s.account, s.container, objectKey(s.prefix, name))
+	// permissions, start, expiry, resource, identifier, IP, protocol,
+	// version, resource type, snapshot time, encryption scope and the five
+	// response header overrides
+	toSign := strings.Join([]string{"r", "", expires, resource, "", "", "https", sasVersion, "b", "", "", "", "", "", "", ""}, "\n")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(toSign))
+
+	q := url.Values{
+		"sv":  {sasVersion},
+		"sp":  {"r"},
+		"se":  {expires},
+		"sr":  {"b"},
+		"spr": {"https"},
+		"sig": {base64.StdEncoding.EncodeToString(mac.Sum(nil))},
+	}
+	return s.blobURL(name) + "?" + q.Encode(), nil
+}
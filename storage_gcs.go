@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+// gcsStorage uploads objects to Google Cloud Storage. The target is
+// gs://<bucket>/<prefix>; ?storageClass= sets the class of new objects and
+// every ?meta.<key>=<value> becomes custom object metadata. Credentials are
+// the application default credentials.
+type gcsStorage struct {
+	client       *gcs.Client
+	bucket       string
+	prefix       string
+	storageClass string
+	metadata     map[string]string
+}
+
+func newGCSStorage(u *url.URL) (*gcsStorage, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("gcs output must look like gs://bucket/prefix")
+	}
+
+	client, err := gcs.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("can't create GCS client: %w", err)
+	}
+
+	s := &gcsStorage{
+		client:   client,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		metadata: map[string]string{},
+	}
+	for k, v := range u.Query() {
+		switch {
+		case k == "storageClass":
+			s.storageClass = v[0]
+		case strings.HasPrefix(k, "meta."):
+			s.metadata[strings.TrimPrefix(k, "meta.")] = v[0]
+		default:
+			client.Close()
+			return nil, fmt.Errorf("unknown gcs output option %q", k)
+		}
+	}
+	return s, nil
+}
+
+func (s *gcsStorage) Put(ctx context.Context, name string, r io.Reader, metadata map[string]string) (string, error) {
+	key := objectKey(s.prefix, name)
+	// cancelling the writer is what keeps a failed upload from committing
+	// what it got so far
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = metadata[metaContentType]
+	w.CacheControl = metadata[metaCacheControl]
+	w.StorageClass = s.storageClass
+	w.Metadata = withMetadata(s.metadata, customMetadata(metadata))
+	w.ChunkSize = uploadBufferSize
+
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gs://%s/%s", s.bucket, key), nil
+}
+
+// SignURL needs credentials that can sign, such as a service account key or
+// the iam.serviceAccounts.signBlob permission.
+func (s *gcsStorage) SignURL(name string, expiry time.Duration) (string, error) {
+	return s.client.Bucket(s.bucket).SignedURL(objectKey(s.prefix, name), &gcs.SignedURLOptions{
+		Method:  "GET",
+		Expires: time.Now().Add(expiry),
+		Scheme:  gcs.SigningSchemeV4,
+	})
+}
+
+func (s *gcsStorage) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Storage uploads objects to Amazon S3 or an S3-compatible store. The target
+// is s3://<bucket>/<prefix>, optionally with ?region= and, for compatible
+// stores, ?endpoint=; credentials come from the AWS environment or profile.
+// Uploads are multipart and streamed, so the size needn't be known up front.
+type s3Storage struct {
+	bucket   string
+	prefix   string
+	uploader *manager.Uploader
+	presign  *s3.PresignClient
+}
+
+func newS3Storage(u *url.URL) (*s3Storage, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 output must look like s3://bucket/prefix")
+	}
+
+	q := u.Query()
+	var opts []func(*awsconfig.LoadOptions) error
+	if region := q.Get("region"); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("can't load AWS configuration: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint := q.Get("endpoint"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	// one part is buffered at a time, see uploadBufferSize
+	uploader := manager.NewUploader(client, func(up *manager.Uploader) {
+		up.PartSize = uploadBufferSize
+		up.Concurrency = 1
+	})
+
+	return &s3Storage{
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		uploader: uploader,
+		presign:  s3.NewPresignClient(client),
+	}, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, r io.Reader, metadata map[string]string) (string, error) {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey(s.prefix, name)),
+		Body:   r,
+	}
+	if ct := metadata[metaContentType]; ct != "" {
+		in.ContentType = aws.String(ct)
+	}
+	if cc := metadata[metaCacheControl]; cc != "" {
+		in.CacheControl = aws.String(cc)
+	}
+	if custom := customMetadata(metadata); len(custom) > 0 {
+		in.Metadata = custom
+	}
+
+	out, err := s.uploader.Upload(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	return out.Location, nil
+}
+
+func (s *s3Storage) SignURL(name string, expiry time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey(s.prefix, name)),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
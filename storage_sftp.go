@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpConfig is the sftp section of config.yaml. The password may also be
+// given in SFTP_PASSWORD; the host key is checked against knownHosts.
+type sftpConfig struct {
+	Host       string `yaml:"host"`
+	Port       int    `yaml:"port"`
+	User       string `yaml:"user"`
+	Password   string `yaml:"password"`
+	KeyFile    string `yaml:"keyFile"`
+	KnownHosts string `yaml:"knownHosts"`
+	RemoteDir  string `yaml:"remoteDir"`
+}
+
+// sftpStorage uploads files over SFTP. The output is sftp:// with everything
+// taken from config.yaml, or sftp://[user@]host[:port]/remote/dir overriding
+// parts of it. Uploads share one SFTP session.
+type sftpStorage struct {
+	host   string
+	dir    string
+	client *ssh.Client
+	sftp   *sftp.Client
+
+	mu   sync.Mutex
+	made map[string]bool
+}
+
+func newSFTPStorage(u *url.URL, conf sftpConfig) (*sftpStorage, error) {
+	if u.Host != "" {
+		conf.Host = u.Hostname()
+		if p := u.Port(); p != "" {
+			conf.Port, _ = strconv.Atoi(p)
+		}
+	}
+	if u.User != nil {
+		conf.User = u.User.Username()
+	}
+	if u.Path != "" {
+		conf.RemoteDir = u.Path
+	}
+	if conf.Port == 0 {
+		conf.Port = 22
+	}
+	if conf.Host == "" || conf.User == "" {
+		return nil, fmt.Errorf("sftp output needs a host and user, in the URL or in the sftp section of config.yaml")
+	}
+
+	config, err := sshClientConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := sftp.NewClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &sftpStorage{host: addr, dir: conf.RemoteDir, client: client, sftp: session, made: map[string]bool{}}, nil
+}
+
+func sshClientConfig(conf sftpConfig) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+	if conf.KeyFile != "" {
+		key, err := os.ReadFile(expandHome(conf.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", conf.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	password := conf.Password
+	if env := os.Getenv("SFTP_PASSWORD"); env != "" {
+		password = env
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp output needs a keyFile or a password")
+	}
+
+	knownHosts := conf.KnownHosts
+	if knownHosts == "" {
+		knownHosts = "~/.ssh/known_hosts"
+	}
+	hostKeys, err := knownhosts.New(expandHome(knownHosts))
+	if err != nil {
+		return nil, fmt.Errorf("can't read known hosts: %w", err)
+	}
+
+	return &ssh.ClientConfig{User: conf.User, Auth: auth, HostKeyCallback: hostKeys}, nil
+}
+
+func expandHome(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return p
+}
+
+// mkdirAll creates dir and its parents once per run.
+func (s *sftpStorage) mkdirAll(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.made[dir] {
+		return nil
+	}
+	if err := s.sftp.MkdirAll(dir); err != nil {
+		return err
+	}
+	s.made[dir] = true
+	return nil
+}
+
+func (s *sftpStorage) Put(_ context.Context, name string, r io.Reader, _ map[string]string) (string, error) {
+	remote := path.Join(s.dir, name)
+	if err := s.mkdirAll(path.Dir(remote)); err != nil {
+		return "", err
+	}
+
+	f, err := s.sftp.OpenFile(remote, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.ReadFrom(r); err != nil {
+		f.Close()
+		s.sftp.Remove(remote)
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	return "sftp://" + s.host + "/" + strings.TrimPrefix(remote, "/"), nil
+}
+
+func (s *sftpStorage) Close() error {
+	s.sftp.Close()
+	return s.client.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// destinationResult is the outcome of storing a file in one tee destination.
+type destinationResult struct {
+	Output   string `json:"output"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// teeStorage streams every file to several destinations in a single pass.
+type teeStorage struct {
+	outputs []string
+	targets []storage
+}
+
+func newTeeStorage(outputs []string, conf *config) (*teeStorage, error) {
+	tee := &teeStorage{outputs: outputs}
+	for _, output := range outputs {
+		s, err := newStorage(output, conf)
+		if err != nil {
+			tee.Close()
+			return nil, fmt.Errorf("%s: %w", output, err)
+		}
+		tee.targets = append(tee.targets, s)
+	}
+	return tee, nil
+}
+
+// putEach copies r to every destination concurrently. A destination that
+// fails stops receiving data without holding up the others.
+func (t *teeStorage) putEach(ctx context.Context, name string, r io.Reader, metadata map[string]string) []destinationResult {
+	results := make([]destinationResult, len(t.targets))
+	writers := make([]io.Writer, len(t.targets))
+	pipes := make([]*io.PipeWriter, len(t.targets))
+
+	var wg sync.WaitGroup
+	for i, target := range t.targets {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+		writers[i] = &detachableWriter{w: pw}
+		results[i].Output = t.outputs[i]
+
+		wg.Add(1)
+		go func(i int, target storage) {
+			defer wg.Done()
+
+			location, err := target.Put(ctx, name, pr, metadata)
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+			results[i].Location = location
+			// unblock the writer if Put returned before reading everything
+			pr.CloseWithError(errDestinationDone)
+		}(i, target)
+	}
+
+	_, err := io.Copy(io.MultiWriter(writers...), r)
+	for _, pw := range pipes {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (t *teeStorage) Put(ctx context.Context, name string, r io.Reader, metadata map[string]string) (string, error) {
+	return summarize(t.putEach(ctx, name, r, metadata))
+}
+
+// signer returns the first destination that can sign URLs.
+func (t *teeStorage) signer() signer {
+	for _, target := range t.targets {
+		if s, ok := target.(signer); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *teeStorage) Close() error {
+	var errs []string
+	for i, target := range t.targets {
+		if closer, ok := target.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", t.outputs[i], err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// summarize returns the first successful location, or an error when no
+// destination accepted the file.
+func summarize(results []destinationResult) (string, error) {
+	var errs []string
+	for _, res := range results {
+		if res.Error == "" {
+			return res.Location, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", res.Output, res.Error))
+	}
+	return "", errors.New(strings.Join(errs, "; "))
+}
+
+var errDestinationDone = errors.New("destination finished")
+
+// detachableWriter swallows writes once its destination has gone away, so
+// one failing destination doesn't abort the copy to the others.
+type detachableWriter struct {
+	w      io.Writer
+	failed bool
+}
+
+func (d *detachableWriter) Write(p []byte) (int, error) {
+	if !d.failed {
+		if _, err := d.w.Write(p); err != nil {
+			d.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// store puts a file into the configured storage, encrypting it first when
+// -encrypt is set. With several outputs every destination is tracked
+// separately and a file only fails when all of them do.
+func store(runOptions *runOptions, name string, r io.Reader, metadata map[string]string) (string, []destinationResult, error) {
+	metadata = withMetadata(runOptions.objectMetadata, metadata)
+	if runOptions.encryption != nil {
+		encrypted, closer := encryptingReader(r, runOptions.encryption)
+		defer closer.Close()
+
+		r, name = encrypted, storedName(runOptions, name)
+		metadata = withContentType(metadata, encContentType)
+	}
+
+	tee, ok := runOptions.storage.(*teeStorage)
+	if !ok {
+		location, err := runOptions.storage.Put(ctx, name, r, metadata)
+		return location, nil, err
+	}
+
+	results := tee.putEach(ctx, name, r, metadata)
+	location, err := summarize(results)
+	return location, results, err
+}
+
+func withContentType(metadata map[string]string, contentType string) map[string]string {
+	return withMetadata(metadata, map[string]string{"content-type": contentType})
+}
+
+// withMetadata returns metadata with the entries of extra added.
+func withMetadata(metadata, extra map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata)+len(extra))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	for k, v := range extra {
+		copied[k] = v
+	}
+	return copied
+}
+
+// storedName is the name store puts name under.
+func storedName(runOptions *runOptions, name string) string {
+	if runOptions.encryption != nil {
+		return name + encryptedExt
+	}
+	return name
+}
+
+// outputSigner returns what signs the URLs of the output, if it can.
+func outputSigner(s storage) signer {
+	if tee, ok := s.(*teeStorage); ok {
+		return tee.signer()
+	}
+	signer, _ := s.(signer)
+	return signer
+}
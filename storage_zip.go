@@ -0,0 +1,51 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// zipStorage collects all screenshots of a run into a single zip archive.
+type zipStorage struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *zip.Writer
+}
+
+func newZipStorage(filePath string) (*zipStorage, error) {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &zipStorage{file: f, w: zip.NewWriter(f)}, nil
+}
+
+func (s *zipStorage) Put(_ context.Context, name string, r io.Reader, _ map[string]string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// images are already compressed, so entries are stored as-is
+	w, err := s.w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return "", err
+	}
+	return s.file.Name() + "#" + name, nil
+}
+
+func (s *zipStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.w.Close(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// streamSink writes every result to stdout as a JSON line as soon as it is
+// recorded (-streamResults), for jq and other tools reading the run as it
+// goes; the log is written to stderr instead.
+type streamSink struct {
+	mu       sync.Mutex
+	enc      *json.Encoder
+	redactor redactor
+}
+
+func newStreamSink(w io.Writer, r redactor) *streamSink {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &streamSink{enc: enc, redactor: r}
+}
+
+func (s *streamSink) record(res *captureResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(s.redactor.result(res))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Issue trackers tickets can be opened in.
+const (
+	trackerJira   = "jira"
+	trackerLinear = "linear"
+)
+
+const (
+	defaultTicketTitle = `Visual change on {{.Path}}{{.Shot}} ({{printf "%.2f" .DiffPercent}}%)`
+	defaultTicketBody  = `{{.A}} and {{.B}} of {{.Path}}{{.Shot}} differ in {{printf "%.2f" .DiffPercent}}% of pixels (run {{.RunID}}).
+{{if .DiffFile}}
+Diff: {{.DiffFile}}
+{{end}}`
+)
+
+// ticketConfig is the tickets section of config.yaml. Once a tracker is set,
+// every shot diff or -compareEnvironments finds changed beyond -diffThreshold
+// becomes a ticket with the before, after and diff images attached. Jira
+// credentials are read from JIRA_EMAIL and JIRA_API_TOKEN; for Linear,
+// Project is the team ID, Labels are label IDs and the key is read from
+// LINEAR_API_KEY.
+type ticketConfig struct {
+	Tracker   string   `yaml:"tracker"`
+	URL       string   `yaml:"url"`
+	Project   string   `yaml:"project"`
+	IssueType string   `yaml:"issueType"`
+	Labels    []string `yaml:"labels"`
+	// Title and Body are text/template templates over the comparison
+	// fields (Path, Shot, A, B, DiffPercent, DiffFile) and RunID.
+	Title string `yaml:"title"`
+	Body  string `yaml:"body"`
+}
+
+type ticket struct {
+	title       string
+	body        string
+	attachments []ticketAttachment
+}
+
+type ticketAttachment struct {
+	name string
+	data []byte
+}
+
+// ticketTracker opens a ticket and returns its key.
+type ticketTracker interface {
+	open(t ticket) (string, error)
+}
+
+// tickets files the regressions of a run.
+type tickets struct {
+	tracker ticketTracker
+	title   *template.Template
+	body    *template.Template
+}
+
+func newTickets(conf ticketConfig) (*tickets, error) {
+	t := &tickets{}
+	var err error
+	if t.title, err = template.New("title").Parse(firstNonEmpty(conf.Title, defaultTicketTitle)); err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+	if t.body, err = template.New("body").Parse(firstNonEmpty(conf.Body, defaultTicketBody)); err != nil {
+		return nil, fmt.Errorf("body: %w", err)
+	}
+	if conf.Project == "" {
+		return nil, fmt.Errorf("tickets need a project")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch conf.Tracker {
+	case trackerJira:
+		j := &jiraTracker{client: client, conf: conf, email: os.Getenv("JIRA_EMAIL"), token: os.Getenv("JIRA_API_TOKEN")}
+		if conf.URL == "" || j.email == "" || j.token == "" {
+			return nil, fmt.Errorf("jira tickets need a url, JIRA_EMAIL and JIRA_API_TOKEN")
+		}
+		if j.conf.IssueType == "" {
+			j.conf.IssueType = "Bug"
+		}
+		t.tracker = j
+	case trackerLinear:
+		l := &linearTracker{client: client, conf: conf, key: os.Getenv("LINEAR_API_KEY")}
+		if l.key == "" {
+			return nil, fmt.Errorf("linear tickets need LINEAR_API_KEY")
+		}
+		if l.conf.URL == "" {
+			l.conf.URL = "https://api.linear.app/graphql"
+		}
+		t.tracker = l
+	default:
+		return nil, fmt.Errorf("tracker must be %s or %s, got %q", trackerJira, trackerLinear, conf.Tracker)
+	}
+	return t, nil
+}
+
+// file opens a ticket for the change c found between before and after and
+// records its key on c. A failure is logged, as it mustn't fail the
+// comparison.
+func (t *tickets) file(runOptions *runOptions, c *comparison, before, after image.Image, d imageDiff, logger *log.Logger) {
+	if t == nil {
+		return
+	}
+
+	data := struct {
+		*comparison
+		RunID string
+	}{runOptions.redactor.comparison(c), runOptions.runID}
+	var title, body strings.Builder
+	if err := t.title.Execute(&title, data); err != nil {
+		logger.Printf("can't open a ticket for %s: title: %v", runOptions.value(c.Path), err)
+		return
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		logger.Printf("can't open a ticket for %s: body: %v", runOptions.value(c.Path), err)
+		return
+	}
+
+	tk := ticket{title: title.String(), body: body.String()}
+	for _, a := range []struct {
+		name string
+		img  image.Image
+	}{{"before.png", before}, {"after.png", after}, {"diff.png", d.image}} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, a.img); err != nil {
+			logger.Printf("can't attach %s to the ticket for %s: %v", a.name, runOptions.value(c.Path), err)
+			continue
+		}
+		tk.attachments = append(tk.attachments, ticketAttachment{a.name, buf.Bytes()})
+	}
+
+	key, err := t.tracker.open(tk)
+	if err != nil {
+		logger.Printf("can't open a ticket for %s: %v", runOptions.value(c.Path), err)
+		return
+	}
+	c.Ticket = key
+	logger.Printf("opened ticket %s for %s%s", key, runOptions.value(c.Path), c.Shot)
+}
+
+// sendRequest sends req and decodes the JSON answer into out, if any.
+func sendRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type jiraTracker struct {
+	client *http.Client
+	conf   ticketConfig
+	email  string
+	token  string
+}
+
+func (j *jiraTracker) open(t ticket) (string, error) {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": j.conf.Project},
+		"summary":     t.title,
+		"description": t.body,
+		"issuetype":   map[string]string{"name": j.conf.IssueType},
+	}
+	if len(j.conf.Labels) > 0 {
+		fields["labels"] = j.conf.Labels
+	}
+	data, _ := json.Marshal(map[string]interface{}{"fields": fields})
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(j.conf.URL, "/")+"/rest/api/2/issue", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(j.email, j.token)
+	var issue struct {
+		Key string `json:"key"`
+	}
+	if err = sendRequest(j.client, req, &issue); err != nil {
+		return "", err
+	}
+
+	if len(t.attachments) == 0 {
+		return issue.Key, nil
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, a := range t.attachments {
+		w, err := form.CreateFormFile("file", a.name)
+		if err != nil {
+			return issue.Key, err
+		}
+		_, _ = w.Write(a.data)
+	}
+	form.Close()
+
+	if req, err = http.NewRequest("POST", fmt.Sprintf("%s/rest/api/2/issue/%s/attachments", strings.TrimSuffix(j.conf.URL, "/"), issue.Key), &body); err != nil {
+		return issue.Key, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check")
+	req.SetBasicAuth(j.email, j.token)
+	if err = sendRequest(j.client, req, nil); err != nil {
+		return issue.Key, fmt.Errorf("opened %s but can't attach the images: %w", issue.Key, err)
+	}
+	return issue.Key, nil
+}
+
+type linearTracker struct {
+	client *http.Client
+	conf   ticketConfig
+	key    string
+}
+
+// query runs a GraphQL operation against the Linear API.
+func (l *linearTracker) query(query string, variables map[string]interface{}, out interface{}) error {
+	data, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, err := http.NewRequest("POST", l.conf.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", l.key)
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err = sendRequest(l.client, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("linear: %s", resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// upload puts an attachment on Linear's storage, returning its URL for the
+// issue description.
+func (l *linearTracker) upload(a ticketAttachment) (string, error) {
+	var out struct {
+		FileUpload struct {
+			UploadFile struct {
+				UploadURL string `json:"uploadUrl"`
+				AssetURL  string `json:"assetUrl"`
+				Headers   []struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				} `json:"headers"`
+			} `json:"uploadFile"`
+		} `json:"fileUpload"`
+	}
+	err := l.query(`mutation($contentType: String!, $filename: String!, $size: Int!) {
+  fileUpload(contentType: $contentType, filename: $filename, size: $size) {
+    uploadFile { uploadUrl assetUrl headers { key value } }
+  }
+}`, map[string]interface{}{"contentType": "image/png", "filename": a.name, "size": len(a.data)}, &out)
+	if err != nil {
+		return "", err
+	}
+
+	f := out.FileUpload.UploadFile
+	req, err := http.NewRequest("PUT", f.UploadURL, bytes.NewReader(a.data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	req.Header.Set("Cache-Control", "public, max-age=31536000")
+	for _, h := range f.Headers {
+		req.Header.Set(h.Key, h.Value)
+	}
+	if err = sendRequest(l.client, req, nil); err != nil {
+		return "", err
+	}
+	return f.AssetURL, nil
+}
+
+func (l *linearTracker) open(t ticket) (string, error) {
+	description := t.body
+	for _, a := range t.attachments {
+		assetURL, err := l.upload(a)
+		if err != nil {
+			return "", fmt.Errorf("can't upload %s: %w", a.name, err)
+		}
+		description += fmt.Sprintf("\n\n![%s](%s)", strings.TrimSuffix(a.name, ".png"), assetURL)
+	}
+
+	input := map[string]interface{}{"teamId": l.conf.Project, "title": t.title, "description": description}
+	if len(l.conf.Labels) > 0 {
+		input["labelIds"] = l.conf.Labels
+	}
+	var out struct {
+		IssueCreate struct {
+			Success bool `json:"success"`
+			Issue   struct {
+				Identifier string `json:"identifier"`
+			} `json:"issue"`
+		} `json:"issueCreate"`
+	}
+	err := l.query(`mutation($input: IssueCreateInput!) {
+  issueCreate(input: $input) { success issue { identifier } }
+}`, map[string]interface{}{"input": input}, &out)
+	if err != nil {
+		return "", err
+	}
+	if !out.IssueCreate.Success {
+		return "", fmt.Errorf("linear did not create the issue")
+	}
+	return out.IssueCreate.Issue.Identifier, nil
+}
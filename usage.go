@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// usage accounts for what a run consumed from the renderer, which is what a
+// metered screenshot API bills for.
+type usage struct {
+	mu         sync.Mutex
+	captures   int64
+	bytes      int64
+	renderTime time.Duration
+}
+
+// quota caps a run's usage; zero values mean unlimited.
+type quota struct {
+	maxCaptures   int64
+	maxBytes      int64
+	maxRenderTime time.Duration
+}
+
+func (u *usage) add(bytes int64, renderTime time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.captures++
+	u.bytes += bytes
+	u.renderTime += renderTime
+}
+
+// exceeded reports which limit of q the run has reached, if any.
+func (u *usage) exceeded(q quota) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	switch {
+	case q.maxCaptures > 0 && u.captures >= q.maxCaptures:
+		return fmt.Errorf("capture quota of %d reached", q.maxCaptures)
+	case q.maxBytes > 0 && u.bytes >= q.maxBytes:
+		return fmt.Errorf("transfer quota of %d bytes reached", q.maxBytes)
+	case q.maxRenderTime > 0 && u.renderTime >= q.maxRenderTime:
+		return fmt.Errorf("render time quota of %s reached", q.maxRenderTime)
+	}
+	return nil
+}
+
+func (u *usage) MarshalJSON() ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return json.Marshal(struct {
+		Captures     int64 `json:"captures"`
+		Bytes        int64 `json:"bytes"`
+		RenderTimeMs int64 `json:"renderTimeMs"`
+	}{u.captures, u.bytes, u.renderTime.Milliseconds()})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// warmupPage is rendered by -warmup when no -warmupURL is given.
+const warmupPage = "about:blank"
+
+// warmUp renders u n times before the run, at half the concurrency, so the
+// renderers' browser pools and caches are warm when the first real captures
+// arrive. The images are discarded and failures only logged; only the size
+// and format of the run apply, a synthetic page has nothing to wait for.
+func warmUp(runOptions *runOptions, u string, n int, logger *log.Logger) {
+	workers := *concurrency / 2
+	if workers < 1 {
+		workers = 1
+	}
+
+	logger.Printf("warming up with %d renders of %s", n, runOptions.value(u))
+	opts := captureOptions{width: runOptions.width, height: runOptions.height, format: runOptions.format, scrollPercent: -1}
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	renders := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range renders {
+				out, err := render(context.Background(), runOptions, u, "warmup."+opts.format, uuid.New().String(), opts)
+				if out != nil {
+					out.discard()
+				}
+				if err != nil {
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					logger.Printf("warm-up render failed: %s", runOptions.within(err.Error(), u))
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		renders <- i
+	}
+	close(renders)
+	wg.Wait()
+
+	logger.Printf("warm-up done in %s, %d of %d renders failed", time.Since(start).Round(time.Millisecond), failed, n)
+}
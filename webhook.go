@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const signatureHeader = "X-Screenshoter-Signature"
+
+// postWebhook delivers body to target, retrying failed attempts with a
+// doubling delay. When secret is set the body is signed with HMAC-SHA256 and
+// the signature is sent as "sha256=<hex>" in the X-Screenshoter-Signature header.
+func postWebhook(target, secret string, body []byte, retries int, logger *log.Logger) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	wait := time.Second
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			logger.Printf("retrying webhook %s in %s: %v", target, wait, lastErr)
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set(signatureHeader, "sha256="+sign(secret, body))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		resp.Body.Close()
+		if resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return lastErr
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
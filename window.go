@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// inputWindow is the part of the input -offset, -limit and -sample pick:
+// the valid URLs after the first offset, at most limit of them, and of
+// those a random sample of sample URLs. Invalid lines aren't counted.
+type inputWindow struct {
+	offset int
+	limit  int
+	sample int
+}
+
+func (w inputWindow) enabled() bool {
+	return w.offset > 0 || w.limit > 0 || w.sample > 0
+}
+
+// windowSource passes on the jobs of its window. Without a sample it
+// stops reading the input once past the limit; a sample is drawn while
+// the whole window is read, and its jobs passed on in input order.
+type windowSource struct {
+	jobs   jobSource
+	window inputWindow
+	seen   int
+
+	sampled bool
+	queue   []sampledJob
+}
+
+type sampledJob struct {
+	j   job
+	err error
+	pos int
+}
+
+func (s *windowSource) next() (job, error) {
+	if s.window.sample <= 0 {
+		return s.scan()
+	}
+	if !s.sampled {
+		if err := s.draw(); err != nil {
+			return job{}, err
+		}
+		s.sampled = true
+	}
+	if len(s.queue) == 0 {
+		return job{}, io.EOF
+	}
+	next := s.queue[0]
+	s.queue = s.queue[1:]
+	return next.j, next.err
+}
+
+// scan returns the next job of the window, past the offset, or io.EOF
+// after the limit.
+func (s *windowSource) scan() (job, error) {
+	for {
+		if s.window.limit > 0 && s.seen >= s.window.offset+s.window.limit {
+			return job{}, io.EOF
+		}
+		j, err := s.jobs.next()
+		if err != nil {
+			return j, err
+		}
+		if s.seen++; s.seen > s.window.offset {
+			return j, nil
+		}
+	}
+}
+
+// draw reads the window into a reservoir of sample jobs; the invalid lines
+// read along are kept to be reported as well.
+func (s *windowSource) draw() error {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var reservoir, invalid []sampledJob
+	valid := 0
+	for pos := 0; ; pos++ {
+		j, err := s.scan()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if j.url == "" {
+				return err
+			}
+			invalid = append(invalid, sampledJob{j, err, pos})
+			continue
+		}
+
+		if valid < s.window.sample {
+			reservoir = append(reservoir, sampledJob{j: j, pos: pos})
+		} else if k := rng.Intn(valid + 1); k < s.window.sample {
+			reservoir[k] = sampledJob{j: j, pos: pos}
+		}
+		valid++
+	}
+
+	s.queue = append(reservoir, invalid...)
+	sort.Slice(s.queue, func(a, b int) bool { return s.queue[a].pos < s.queue[b].pos })
+	return nil
+}
+
+func (s *windowSource) Close() error {
+	if closer, ok := s.jobs.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}